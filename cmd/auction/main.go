@@ -2,18 +2,74 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"log"
+	"math/big"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/nukowsk/bukowskis/internal/auction"
 	"github.com/nukowsk/bukowskis/internal/sender"
 	"github.com/nukowsk/bukowskis/internal/simulation"
 	"github.com/nukowsk/bukowskis/internal/store"
+	"github.com/nukowsk/bukowskis/internal/types"
 )
 
+type gasOracle interface {
+	auction.GasGetter
+	SetHistory(*auction.GasHistory)
+	Run()
+}
+
+func newCompositeGasService(vanilla *rpc.Client, gasStationURL string) *auction.CompositeGasService {
+	floor, ok := new(big.Int).SetString(os.Getenv("BUKOWSKIS_GAS_FLOOR"), 10)
+	if !ok {
+		floor = big.NewInt(1e9)
+		log.Printf("defaulting to gas floor %s", floor)
+	}
+
+	sources := []auction.GasSource{
+		auction.NewNodeGasSourceWithClient(vanilla),
+		auction.NewEthGasStationSource(gasStationURL),
+	}
+	return auction.NewCompositeGasService(sources, floor, 15*time.Second, 2*time.Minute)
+}
+
+// BUKOWSKIS_VANILLA_URL is a comma separated list of nodes, the first is
+// the primary. BUKOWSKIS_VANILLA_WEIGHTS optionally weights them in the
+// same order.
+func newUpstreamPool() (*auction.UpstreamPool, error) {
+	urls := []*url.URL{}
+	for _, raw := range strings.Split(os.Getenv("BUKOWSKIS_VANILLA_URL"), ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+
+	weights := []int{}
+	if raw := os.Getenv("BUKOWSKIS_VANILLA_WEIGHTS"); raw != "" {
+		for _, w := range strings.Split(raw, ",") {
+			weight, err := strconv.Atoi(strings.TrimSpace(w))
+			if err != nil {
+				return nil, err
+			}
+			weights = append(weights, weight)
+		}
+	}
+
+	return auction.NewUpstreamPool(urls, weights, 5*time.Second, 3, 2*time.Second)
+}
+
 func main() {
 	log.Print("starting action server")
 
@@ -25,10 +81,10 @@ func main() {
 	}
 	defer client.Close()
 
-	store, err := store.NewFirestore(projectId)
-	// store, err := store.NewLocal()
+	txStore, err := store.NewFirestore(projectId)
+	// txStore, err := store.NewLocal()
 	if err != nil {
-		log.Fatalf("Couldn't initialize store: %v\n", store)
+		log.Fatalf("Couldn't initialize store: %v\n", txStore)
 	}
 
 	bidderURL, err := url.Parse(os.Getenv("BUKOWSKIS_BIDDER_URL"))
@@ -38,23 +94,47 @@ func main() {
 
 	log.Printf("Starting bidder proxy: %s", bidderURL)
 
-	vanillaURL, err := url.Parse(os.Getenv("BUKOWSKIS_VANILLA_URL"))
+	// Every use of the vanilla node goes through the pool
+	pool, err := newUpstreamPool()
+	if err != nil {
+		log.Fatalf("Set environment variable BUKOWSKIS_VANILLA_URL: %s\n", err)
+	}
+	vanillaRPC, err := pool.Dial()
 	if err != nil {
-		log.Fatalf("Set environment variable BUKOWSKIS_VANILLA_URL")
+		log.Fatalf("Failed to connect to vanilla node: %s\n", err)
 	}
+	vanillaURL := strings.Split(os.Getenv("BUKOWSKIS_VANILLA_URL"), ",")[0]
 
-	log.Printf("Starting vanilla proxy: %s", vanillaURL)
+	log.Printf("Starting vanilla proxy: %s", os.Getenv("BUKOWSKIS_VANILLA_URL"))
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 		log.Printf("defaulting to port %s", port)
 	}
 
-	url := "https://ethgasstation.info/json/ethgasAPI.json"
-	gasService, err := auction.NewGasService(url)
+	// BUKOWSKIS_GAS_ORACLE selects a single gas source, either node or
+	// ethgasstation. By default both are combined above a static floor.
+	gasStationURL := "https://ethgasstation.info/json/ethgasAPI.json"
+	var gasService gasOracle
+	switch os.Getenv("BUKOWSKIS_GAS_ORACLE") {
+	case "node":
+		gasService, err = auction.NewNodeGasServiceWithClient(vanillaRPC)
+	case "ethgasstation":
+		gasService, err = auction.NewGasService(gasStationURL)
+	default:
+		gasService = newCompositeGasService(vanillaRPC, gasStationURL)
+	}
 	if err != nil {
 		log.Fatalf("failed to initialize gas service: %s\n", err)
 	}
+
+	// BUKOWSKIS_GAS_HISTORY_STORE=true also persists every reading
+	var gasStore store.Store
+	if os.Getenv("BUKOWSKIS_GAS_HISTORY_STORE") == "true" {
+		gasStore = txStore
+	}
+	gasHistory := auction.NewGasHistory(1000, gasStore)
+	gasService.SetHistory(gasHistory)
 	simulate := os.Getenv("BUKOWSKIS_SIMULATE") == "true"
 	if simulate {
 		auctionAddr := os.Getenv("BUKOWSKIS_AUCTION_ADDR")
@@ -70,7 +150,7 @@ func main() {
 
 		service, err := simulation.NewService(
 			auctionAddr,
-			vanillaURL.String(),
+			vanillaURL,
 			keysDir,
 			1*time.Minute)
 		if err != nil {
@@ -80,19 +160,203 @@ func main() {
 		go service.Run(-1)
 	}
 
-	proxy := auction.NewProxy(vanillaURL)
-	sender := sender.NewHTTPSender(bidderURL.String())
+	proxy := auction.NewProxy(pool)
+	workers, err := strconv.Atoi(os.Getenv("BUKOWSKIS_SENDER_WORKERS"))
+	if err != nil {
+		workers = 8
+		log.Printf("defaulting to %d sender workers", workers)
+	}
+
+	queueSize, err := strconv.Atoi(os.Getenv("BUKOWSKIS_SENDER_QUEUE"))
+	if err != nil {
+		queueSize = 256
+		log.Printf("defaulting to sender queue size %d", queueSize)
+	}
+
+	// Receipts name the bidders' URLs so they're only served with a token
+	receiptsToken := os.Getenv("BUKOWSKIS_RECEIPTS_TOKEN")
+	receipts := sender.NewReceipts(1000, receiptsToken)
+
+	// Bidders with a stream token connect to /stream instead of being
+	// sent a request per transaction
+	var bidderSender sender.Sender
+	var streamSender *sender.StreamSender
+	var queuedSender *sender.QueuedSender
+	drained := make(chan struct{})
+	bidderName := bidderURL.Host
+	var bidderKey *ecdsa.PublicKey
+	bidderPubkey := os.Getenv("BUKOWSKIS_BIDDER_PUBKEY")
+	if bidderPubkey != "" {
+		bidderKey, err = types.ParsePublicKey(bidderPubkey)
+		if err != nil {
+			log.Fatalf("Invalid BUKOWSKIS_BIDDER_PUBKEY: %s\n", err)
+		}
+		log.Println("Encrypting transactions to bidder")
+	}
+	streamToken := os.Getenv("BUKOWSKIS_BIDDER_STREAM_TOKEN")
+	if streamToken != "" {
+		if bidderKey != nil {
+			streamSender = sender.NewEncryptedStreamSender(streamToken, queueSize, bidderKey)
+		} else {
+			streamSender = sender.NewStreamSender(streamToken, queueSize)
+		}
+		streamSender.SetRecorder(receipts)
+		bidderSender = streamSender
+		bidderName = "stream"
+	} else {
+		httpSender := sender.NewHTTPSender(bidderURL.String())
+		if bidderKey != nil {
+			httpSender = sender.NewEncryptedHTTPSender(bidderURL.String(), bidderKey)
+		}
+		httpSender.SetRecorder(receipts)
+
+		queuedSender = sender.NewQueuedSender(
+			httpSender,
+			workers,
+			queueSize)
+		go func() {
+			queuedSender.Run()
+			close(drained)
+		}()
+		bidderSender = queuedSender
+	}
+
+	hints := auction.HintPolicies{}
+	hintsPath := os.Getenv("BUKOWSKIS_HINT_POLICY")
+	if hintsPath != "" {
+		hints, err = auction.LoadHintPolicies(hintsPath)
+		if err != nil {
+			log.Fatalf("Failed to load hint policy: %s\n", err)
+		}
+	}
+
+	gasPolicies := auction.GasPolicies{}
+	gasPolicyPath := os.Getenv("BUKOWSKIS_GAS_POLICY")
+	if gasPolicyPath != "" {
+		gasPolicies, err = auction.LoadGasPolicies(gasPolicyPath)
+		if err != nil {
+			log.Fatalf("Failed to load gas policy: %s\n", err)
+		}
+	}
+
+	rateLimits := auction.RateLimitConfig{}
+	rateLimitPath := os.Getenv("BUKOWSKIS_RATE_LIMIT")
+	if rateLimitPath != "" {
+		rateLimits, err = auction.LoadRateLimitConfig(rateLimitPath)
+		if err != nil {
+			log.Fatalf("Failed to load rate limits: %s\n", err)
+		}
+	}
+
+	vanillaClient := ethclient.NewClient(vanillaRPC)
+
+	// Account state is cached briefly so bursts from one sender are cheap
+	validator := auction.NewStateValidator(vanillaClient, 2*time.Second)
+
+	// Delivered transactions are followed until they are included or dropped
+	blocks := auction.NewBlockWatcher(vanillaClient, 3*time.Second)
+	tracker := auction.NewStatusTracker(txStore, bidderName)
+	tracker.Watch(blocks, vanillaClient)
+
+	// Accepted transactions are kept until included so wallets can look
+	// them up and count them in their next nonce, and those behind a nonce
+	// gap are held back
+	pending := auction.NewPendingPool(blocks, vanillaClient, vanillaRPC, tracker)
+
+	// Repeated reads are answered from a cache cleared on every new head
 	server, err := auction.NewAuctionService(
 		port,
-		proxy,
-		sender,
-		store,
-		gasService)
+		auction.NewCachingProxy(proxy, blocks),
+		bidderSender,
+		tracker,
+		gasService,
+		hints,
+		gasPolicies,
+		validator,
+		auction.NewRateLimiter(rateLimits),
+		pending)
 	if err != nil {
 		log.Fatalf("Failed to initialize auction server: %s\n", err)
 	}
 
+	methodPolicyPath := os.Getenv("BUKOWSKIS_METHOD_POLICY")
+	if methodPolicyPath != "" {
+		methodPolicy, err := auction.LoadMethodPolicy(methodPolicyPath)
+		if err != nil {
+			log.Fatalf("Failed to load method policy: %s\n", err)
+		}
+		server.SetMethodPolicy(methodPolicy)
+	}
+
+	if receiptsToken != "" {
+		server.Handle("/receipts", receipts)
+	}
+	server.Register("bukowskis_gasPrice", auction.GasPriceMethod(gasService, gasPolicies))
+	server.Register("bukowskis_gasHistory", gasHistory.Method())
+	server.Register("bukowskis_getTransactionStatus", tracker.StatusMethod())
+	server.Register("eth_getTransactionByHash", pending.GetTransactionMethod())
+	server.Register("eth_getTransactionCount", pending.TransactionCountMethod())
+
+	// Reserved transactions are held until their target block
+	reserve := auction.NewReserveBook(blocks, server.CheckTransaction, server.ProcessTransaction)
+	server.RegisterSend("eth_sendRawTransaction_reserve", reserve.SendMethod())
+	server.RegisterSend("eth_sendTransaction_reserve", reserve.SendMethod())
+	server.RegisterSend("bukowskis_cancelReservation", reserve.CancelMethod())
+	server.Register("bukowskis_getReservation", reserve.StatusMethod())
+
+	// Searcher bundles are delivered to the winner of their target block
+	bundleSender, ok := bidderSender.(sender.BundleSender)
+	if !ok {
+		log.Fatalln("Bidder sender does not support bundles")
+	}
+	bundles := auction.NewBundleBook(blocks, tracker, bundleSender)
+	server.RegisterSend("eth_sendBundle", bundles.SendMethod())
+
+	// Private transactions are delivered to every winner until included
+	private := auction.NewPrivateBook(
+		blocks,
+		vanillaClient,
+		server.ProcessTransaction,
+		server.DeliverTransaction)
+	server.RegisterSend("eth_sendPrivateTransaction", private.SendMethod())
+	server.RegisterSend("eth_cancelPrivateTransaction", private.CancelMethod())
+
+	// Websocket clients are proxied to the vanilla node's websocket so
+	// subscriptions work
+	vanillaWSURL := os.Getenv("BUKOWSKIS_VANILLA_WS_URL")
+	if vanillaWSURL != "" {
+		// Every client holds its own connection to the vanilla node
+		maxConnections, err := strconv.Atoi(os.Getenv("BUKOWSKIS_WS_MAX_CONNECTIONS"))
+		if err != nil {
+			maxConnections = 256
+			log.Printf("defaulting to %d websocket connections", maxConnections)
+		}
+		log.Printf("Serving websockets on /ws, proxied to %s", vanillaWSURL)
+		server.HandleWebsocket("/ws", vanillaWSURL, maxConnections)
+	}
+
+	if streamSender != nil {
+		log.Println("Streaming to bidder on /stream")
+		server.Handle("/stream", streamSender)
+	}
+
 	log.Printf("listening on port %s", port)
+	go pool.Run()
 	go gasService.Run()
+	go blocks.Run()
+
+	// On shutdown, transactions already accepted are delivered before
+	// exiting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("shutting down")
+		server.Stop()
+	}()
 	server.Run()
+	if queuedSender != nil {
+		queuedSender.Stop()
+		<-drained
+	}
 }
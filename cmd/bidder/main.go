@@ -1,22 +1,138 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/websocket"
+	"github.com/nukowsk/bukowskis/internal/sender"
 	"github.com/nukowsk/bukowskis/internal/types"
 )
 
 func validRequest(req types.JsRequest) bool {
-	return req.Method == "eth_sendRawTransaction"
+	return req.Method == "eth_sendRawTransaction" ||
+		req.Method == "bukowskis_sendEncryptedTransaction"
+}
+
+func decryptBundle(jsr types.JsRequest, key *ecdsa.PrivateKey) (types.SendBundleArgs, error) {
+	if len(jsr.Params) != 1 {
+		return types.SendBundleArgs{}, fmt.Errorf("expected a bundle")
+	}
+	data, err := json.Marshal(jsr.Params[0])
+	if err != nil {
+		return types.SendBundleArgs{}, err
+	}
+	var bundle types.SendBundleArgs
+	err = json.Unmarshal(data, &bundle)
+	if err != nil {
+		return types.SendBundleArgs{}, err
+	}
+	return types.DecryptBundle(bundle, key)
+}
+
+func streamedTransaction(msg sender.StreamMessage, key *ecdsa.PrivateKey) (*ethtypes.Transaction, error) {
+	if msg.Encrypted {
+		return types.DecryptTransaction(msg.Transaction, key)
+	}
+	return types.ParseTransaction(msg.Transaction)
+}
+
+// Consume transactions from the auction's websocket stream, reconnecting
+// and resuming from the last acknowledged sequence number. The auction
+// restarts the stream if its epoch has changed. key decrypts encrypted
+// messages and may be nil.
+func stream(streamURL string, token string, key *ecdsa.PrivateKey) {
+	epoch := ""
+	next := uint64(0)
+	for {
+		u, err := url.Parse(streamURL)
+		if err != nil {
+			log.Fatalf("Invalid BUKOWSKIS_BIDDER_STREAM_URL: %s\n", err)
+		}
+		q := u.Query()
+		q.Set("from", strconv.FormatUint(next, 10))
+		q.Set("epoch", epoch)
+		u.RawQuery = q.Encode()
+
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+token)
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+		if err != nil {
+			log.Printf("Stream connection failed: %s\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		log.Printf("Streaming from %d\n", next)
+		for {
+			var msg sender.StreamMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				log.Printf("Stream read failed: %s\n", err)
+				break
+			}
+
+			if msg.Encrypted && key == nil {
+				log.Printf("Encrypted message %d without BUKOWSKIS_BIDDER_KEY\n", msg.Seq)
+			} else if msg.Hint != nil {
+				log.Printf("Streamed hint: %+v\n", *msg.Hint)
+			} else if msg.Bundle != nil {
+				bundle := *msg.Bundle
+				var err error
+				if msg.Encrypted {
+					bundle, err = types.DecryptBundle(bundle, key)
+				}
+				if err != nil {
+					log.Printf("Invalid bundle %d: %s\n", msg.Seq, err)
+				} else {
+					log.Printf("Streamed bundle %s of %d for block %d\n", msg.Hash, len(bundle.Txs), bundle.BlockNumber)
+				}
+			} else if tx, err := streamedTransaction(msg, key); err != nil {
+				log.Printf("Invalid transaction %d: %s\n", msg.Seq, err)
+			} else {
+				log.Printf("Streamed: %s\n", tx.Hash().Hex())
+			}
+
+			epoch = msg.Epoch
+			next = msg.Seq + 1
+			if err := conn.WriteJSON(sender.StreamAck{Ack: msg.Seq}); err != nil {
+				log.Printf("Stream ack failed: %s\n", err)
+				break
+			}
+		}
+		conn.Close()
+	}
 }
 
 func main() {
 	log.Println("Starting Monopolistic Bidder")
 
+	// Private key matching the public key registered with the auction,
+	// used to decrypt encrypted transactions
+	var key *ecdsa.PrivateKey
+	if hexKey := os.Getenv("BUKOWSKIS_BIDDER_KEY"); hexKey != "" {
+		var err error
+		key, err = crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+		if err != nil {
+			log.Fatalf("Invalid BUKOWSKIS_BIDDER_KEY: %s\n", err)
+		}
+	}
+
+	streamURL := os.Getenv("BUKOWSKIS_BIDDER_STREAM_URL")
+	if streamURL != "" {
+		stream(streamURL, os.Getenv("BUKOWSKIS_BIDDER_STREAM_TOKEN"), key)
+		return
+	}
+
 	http.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
 		jsr, err := types.ParseRequest(req)
 		if err != nil {
@@ -25,19 +141,38 @@ func main() {
 			return
 		}
 		log.Printf("Request: %#v\n", jsr)
+		if jsr.Method == "bukowskis_sendEncryptedBundle" && key != nil {
+			if bundle, err := decryptBundle(jsr, key); err != nil {
+				log.Printf("Invalid encrypted bundle: %s\n", err)
+			} else {
+				log.Printf("Bundle of %d for block %d\n", len(bundle.Txs), bundle.BlockNumber)
+			}
+		}
+		if jsr.Method == "bukowskis_sendHint" || jsr.Method == "eth_sendBundle" ||
+			jsr.Method == "bukowskis_sendEncryptedBundle" {
+			err = json.NewEncoder(res).Encode(types.NewJsResult(jsr.ID, true))
+			if err != nil {
+				log.Printf("Encoding error %s", err)
+			}
+			return
+		}
+
 		if !validRequest(jsr) {
 			log.Printf("Invalid request: %s\n", jsr)
 		}
 
-		tx, err := types.ExtractTransaction(jsr)
+		var tx *ethtypes.Transaction
+		if jsr.Method == "bukowskis_sendEncryptedTransaction" && key != nil {
+			tx, err = types.ExtractEncryptedTransaction(jsr, key)
+		} else {
+			tx, err = types.ExtractTransaction(jsr)
+		}
 		var resp types.JsResponse
 		if err != nil {
-			// what's the correct error code here?
-			resp = types.NewJsError(0, "Invalid payload")
+			resp = types.NewJsError(types.CodeInvalidParams, "Invalid payload")
+			resp.ID = jsr.ID
 		} else {
-			resp = types.JsResponse{
-				Result: tx.Hash().Hex(),
-			}
+			resp = types.NewJsResult(jsr.ID, tx.Hash().Hex())
 		}
 
 		err = json.NewEncoder(res).Encode(resp)
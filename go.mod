@@ -4,7 +4,8 @@ go 1.16
 
 require (
 	cloud.google.com/go/firestore v1.5.0
-	github.com/ethereum/go-ethereum v1.10.3
+	github.com/ethereum/go-ethereum v1.10.8
+	github.com/gorilla/websocket v1.4.2
 	github.com/mitchellh/hashstructure v1.1.0 // indirect
 	github.com/mitchellh/hashstructure/v2 v2.0.2
 	github.com/ybbus/jsonrpc/v2 v2.1.6
@@ -0,0 +1,107 @@
+package auction
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type BlockNumberer interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// BlockWatcher polls the vanilla node for the chain head and notifies
+// subscribers of every new head
+type BlockWatcher struct {
+	client      BlockNumberer
+	interval    time.Duration
+	mx          sync.Mutex
+	head        uint64
+	subscribers []func(uint64)
+	fin         chan struct{}
+}
+
+func NewBlockWatcher(client BlockNumberer, interval time.Duration) *BlockWatcher {
+	return &BlockWatcher{
+		client:      client,
+		interval:    interval,
+		subscribers: []func(uint64){},
+		fin:         make(chan struct{}),
+	}
+}
+
+// Subscribe must be called before Run
+func (b *BlockWatcher) Subscribe(fn func(uint64)) {
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Head is the latest block seen, 0 before the first poll
+func (b *BlockWatcher) Head() uint64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.head
+}
+
+func (b *BlockWatcher) Run() {
+	log.Println("running block watcher")
+	timer := time.NewTicker(b.interval)
+	defer timer.Stop()
+	b.poll()
+	for {
+		select {
+		case <-timer.C:
+			b.poll()
+		case <-b.fin:
+			return
+		}
+	}
+}
+
+func (b *BlockWatcher) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), b.interval)
+	defer cancel()
+	head, err := b.client.BlockNumber(ctx)
+	if err != nil {
+		log.Printf("Failed to get block number: %s\n", err)
+		return
+	}
+
+	b.mx.Lock()
+	if head <= b.head {
+		b.mx.Unlock()
+		return
+	}
+	b.head = head
+	b.mx.Unlock()
+
+	for _, fn := range b.subscribers {
+		fn(head)
+	}
+}
+
+func (b *BlockWatcher) Stop() {
+	close(b.fin)
+}
+
+// Block numbers are accepted as hex quantities or plain numbers
+func parseBlockNumber(param interface{}) (uint64, error) {
+	switch v := param.(type) {
+	case string:
+		n, err := hexutil.DecodeUint64(v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid block number %q: %s", v, err)
+		}
+		return n, nil
+	case float64:
+		if v < 0 {
+			return 0, fmt.Errorf("Invalid block number %v", v)
+		}
+		return uint64(v), nil
+	default:
+		return 0, fmt.Errorf("Invalid block number %v", param)
+	}
+}
@@ -0,0 +1,254 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nukowsk/bukowskis/internal/sender"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Decode a generic JSON-RPC param into v
+func decodeParam(param interface{}, v interface{}) error {
+	data, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+const (
+	// Bundles can target at most this many blocks ahead of the head
+	bundleMaxBlocks = 128
+
+	// Most bundles held for one target block and transactions in a bundle
+	bundleMaxPerBlock = 256
+	bundleMaxTxs      = 32
+
+	// Used to estimate the target block's timestamp
+	bundleBlockTime = 12 * time.Second
+)
+
+var errBundlesFull = &bt.JsError{Code: bt.CodeServerBusy, Message: "Too many bundles for the target block"}
+
+// BundleBook holds searcher bundles until the auction for their target
+// block and then delivers them to the winner
+type BundleBook struct {
+	mx      sync.Mutex
+	bundles map[uint64][]bt.SendBundleArgs
+	blocks  *BlockWatcher
+	tracker *StatusTracker
+	sender  sender.BundleSender
+}
+
+func NewBundleBook(blocks *BlockWatcher, tracker *StatusTracker, sender sender.BundleSender) *BundleBook {
+	b := &BundleBook{
+		bundles: map[uint64][]bt.SendBundleArgs{},
+		blocks:  blocks,
+		tracker: tracker,
+		sender:  sender,
+	}
+	blocks.Subscribe(b.onBlock)
+	return b
+}
+
+func (b *BundleBook) Add(bundle bt.SendBundleArgs) (common.Hash, error) {
+	txs, err := bundle.Transactions()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(txs) == 0 || len(txs) > bundleMaxTxs {
+		return common.Hash{}, fmt.Errorf("Bundles should have between 1 and %d transactions", bundleMaxTxs)
+	}
+
+	target := uint64(bundle.BlockNumber)
+	head := b.blocks.Head()
+	if head == 0 || target <= head {
+		return common.Hash{}, fmt.Errorf("Target block %d has already been mined", target)
+	}
+	if target > head+bundleMaxBlocks {
+		return common.Hash{}, fmt.Errorf("Target block %d is more than %d blocks ahead", target, bundleMaxBlocks)
+	}
+
+	if bundle.MinTimestamp != nil && bundle.MaxTimestamp != nil &&
+		*bundle.MaxTimestamp < *bundle.MinTimestamp {
+		return common.Hash{}, fmt.Errorf("maxTimestamp is before minTimestamp")
+	}
+	if bundle.MaxTimestamp != nil && *bundle.MaxTimestamp < uint64(time.Now().Unix()) {
+		return common.Hash{}, fmt.Errorf("maxTimestamp has already passed")
+	}
+
+	included := map[common.Hash]bool{}
+	for _, tx := range txs {
+		included[tx.Hash()] = true
+	}
+	for _, hash := range bundle.RevertingTxHashes {
+		if !included[hash] {
+			return common.Hash{}, fmt.Errorf("Reverting transaction %s is not in the bundle", hash.Hex())
+		}
+	}
+
+	b.mx.Lock()
+	full := len(b.bundles[target]) >= bundleMaxPerBlock
+	b.mx.Unlock()
+	if full {
+		return common.Hash{}, errBundlesFull
+	}
+
+	// The same transaction is often sent in bundles for several blocks so
+	// it may already be stored
+	for _, tx := range txs {
+		err = b.tracker.Received(tx, nil)
+		if err != nil {
+			log.Printf("Failed to store bundle transaction %s: %s\n", tx.Hash().Hex(), err)
+		}
+	}
+
+	hash, err := bundle.Hash()
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	// Filled up while the transactions were stored
+	b.mx.Lock()
+	if len(b.bundles[target]) >= bundleMaxPerBlock {
+		b.mx.Unlock()
+		b.dropUnheld(txs, "too many bundles for the target block")
+		return common.Hash{}, errBundlesFull
+	}
+	b.bundles[target] = append(b.bundles[target], bundle)
+	b.mx.Unlock()
+	return hash, nil
+}
+
+// Record the transactions as dropped unless a bundle still held for a
+// later block contains them. Those delivered in an earlier bundle may
+// still be included and are left alone.
+func (b *BundleBook) dropUnheld(txs []*types.Transaction, reason string) {
+	held := map[common.Hash]bool{}
+	b.mx.Lock()
+	for _, bundles := range b.bundles {
+		for _, bundle := range bundles {
+			bundleTxs, _ := bundle.Transactions()
+			for _, tx := range bundleTxs {
+				held[tx.Hash()] = true
+			}
+		}
+	}
+	b.mx.Unlock()
+
+	for _, tx := range txs {
+		if held[tx.Hash()] {
+			continue
+		}
+		status, _ := b.tracker.Status(tx.Hash().Hex())
+		if status != nil && status.Status == st.StatusReceived {
+			b.tracker.Dropped(tx, reason)
+		}
+	}
+}
+
+func (b *BundleBook) onBlock(head uint64) {
+	b.mx.Lock()
+	due := b.bundles[head+1]
+	for target := range b.bundles {
+		if target <= head+1 {
+			delete(b.bundles, target)
+		}
+	}
+	b.mx.Unlock()
+
+	// Transactions of bundles which weren't sent are dropped unless
+	// another bundle for the block sent them
+	delivered := map[common.Hash]bool{}
+	undelivered := map[string][]*types.Transaction{}
+
+	// The target block is expected one block time from now
+	expected := uint64(time.Now().Add(bundleBlockTime).Unix())
+	for _, bundle := range due {
+		txs, _ := bundle.Transactions()
+		if (bundle.MinTimestamp != nil && expected < *bundle.MinTimestamp) ||
+			(bundle.MaxTimestamp != nil && expected > *bundle.MaxTimestamp) {
+			log.Printf("Skipping bundle for block %d outside its timestamp window\n", head+1)
+			reason := "bundle outside its timestamp window"
+			undelivered[reason] = append(undelivered[reason], txs...)
+			continue
+		}
+
+		// Queued senders report the delivery themselves, possibly before
+		// SendBundle returns
+		_, async := b.sender.(sender.AsyncSender)
+		if async {
+			for _, tx := range txs {
+				b.tracker.Queued(tx)
+			}
+		}
+
+		result, err := b.sender.SendBundle(bundle)
+		if err != nil {
+			log.Printf("Failed to deliver bundle for block %d: %s\n", head+1, err)
+			if async {
+				for _, tx := range txs {
+					b.tracker.Failed(tx, err)
+				}
+			} else {
+				reason := "bundle delivery failed: " + err.Error()
+				undelivered[reason] = append(undelivered[reason], txs...)
+			}
+			continue
+		}
+		log.Printf("Delivered bundle %s for block %d\n", result, head+1)
+
+		for _, tx := range txs {
+			delivered[tx.Hash()] = true
+			if !async {
+				b.tracker.Delivered(tx)
+			}
+		}
+	}
+
+	for reason, txs := range undelivered {
+		dropped := []*types.Transaction{}
+		for _, tx := range txs {
+			if !delivered[tx.Hash()] {
+				dropped = append(dropped, tx)
+			}
+		}
+		b.dropUnheld(dropped, reason)
+	}
+}
+
+type sendBundleResult struct {
+	BundleHash common.Hash `json:"bundleHash"`
+}
+
+func (b *BundleBook) SendMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		if len(jsr.Params) != 1 {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected a bundle"}
+		}
+
+		var bundle bt.SendBundleArgs
+		err := decodeParam(jsr.Params[0], &bundle)
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: fmt.Sprintf("Invalid bundle: %s", err)}
+		}
+
+		hash, err := b.Add(bundle)
+		if err == errBundlesFull {
+			return nil, err
+		} else if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: err.Error()}
+		}
+
+		log.Printf("Bundle: %s for block %d\n", hash.Hex(), bundle.BlockNumber)
+		return sendBundleResult{BundleHash: hash}, nil
+	}
+}
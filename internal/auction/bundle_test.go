@@ -0,0 +1,111 @@
+package auction
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+type recordingBundleSender struct {
+	sent []bt.SendBundleArgs
+}
+
+func (r *recordingBundleSender) SendBundle(bundle bt.SendBundleArgs) (string, error) {
+	r.sent = append(r.sent, bundle)
+	return "", nil
+}
+
+func TestBundleBook(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	store, _ := st.NewLocal()
+	tracker := NewStatusTracker(store, "mock")
+	bundleSender := &recordingBundleSender{}
+	book := NewBundleBook(blocks, tracker, bundleSender)
+
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	encoded, err := bt.HexEncodeTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 100}); err == nil {
+		t.Error("expected a mined block to be rejected")
+	}
+	reverting := bt.SendBundleArgs{
+		Txs:               []string{encoded},
+		BlockNumber:       103,
+		RevertingTxHashes: []common.Hash{{1}},
+	}
+	if _, err := book.Add(reverting); err == nil {
+		t.Error("expected an unknown reverting hash to be rejected")
+	}
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 101 + bundleMaxBlocks}); err == nil {
+		t.Error("expected a far future block to be rejected")
+	}
+	past := uint64(time.Now().Add(-time.Minute).Unix())
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 103, MaxTimestamp: &past}); err == nil {
+		t.Error("expected a passed maxTimestamp to be rejected")
+	}
+	tooMany := make([]string, bundleMaxTxs+1)
+	for i := range tooMany {
+		tooMany[i] = encoded
+	}
+	if _, err := book.Add(bt.SendBundleArgs{Txs: tooMany, BlockNumber: 103}); err == nil {
+		t.Error("expected a bundle with too many transactions to be rejected")
+	}
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 103}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not delivered while the target block is expected before its window
+	future := uint64(time.Now().Add(time.Hour).Unix())
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 103, MinTimestamp: &future}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transaction only in a skipped bundle is dropped
+	skipped := testTx(t, 1, 1)
+	encodedSkipped, err := bt.HexEncodeTransaction(skipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encodedSkipped}, BlockNumber: 103, MinTimestamp: &future}); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.head = 101
+	blocks.poll()
+	if len(bundleSender.sent) != 0 {
+		t.Fatal("bundle delivered before its auction")
+	}
+
+	chain.head = 102
+	blocks.poll()
+	if len(bundleSender.sent) != 1 {
+		t.Fatalf("expected the bundle to be delivered, got %d", len(bundleSender.sent))
+	}
+	if status, _ := tracker.Status(tx.Hash().Hex()); status == nil || status.Status != st.StatusDelivered {
+		t.Errorf("expected the transaction sent in another bundle to be delivered, got %+v", status)
+	}
+	if status, _ := tracker.Status(skipped.Hash().Hex()); status == nil || status.Status != st.StatusDropped {
+		t.Errorf("expected the skipped transaction to be dropped, got %+v", status)
+	}
+
+	// Bundles for one block are capped
+	for i := 0; i < bundleMaxPerBlock; i++ {
+		if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 104}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := book.Add(bt.SendBundleArgs{Txs: []string{encoded}, BlockNumber: 104}); err != errBundlesFull {
+		t.Errorf("expected the block to be full, got %v", err)
+	}
+}
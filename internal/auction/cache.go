@@ -0,0 +1,351 @@
+package auction
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Blocks this far behind the head are assumed final and cached forever
+const cacheFinalityDepth = 64
+
+// Entries beyond either limit are evicted, head scoped ones first.
+// Results larger than cacheMaxEntryBytes, such as blocks with full
+// transactions, aren't cached at all.
+const (
+	cacheMaxEntries    = 10000
+	cacheMaxBytes      = 64 << 20
+	cacheMaxEntryBytes = 1 << 20
+)
+
+// Results which never change
+var immutableMethods = map[string]bool{
+	"eth_chainId":                           true,
+	"net_version":                           true,
+	"eth_getBlockByHash":                    true,
+	"eth_getBlockTransactionCountByHash":    true,
+	"eth_getTransactionByBlockHashAndIndex": true,
+	"eth_getUncleCountByBlockHash":          true,
+}
+
+// Results which only change with the head
+var headMethods = map[string]bool{
+	"eth_blockNumber":          true,
+	"eth_gasPrice":             true,
+	"eth_maxPriorityFeePerGas": true,
+}
+
+// Methods taking a block parameter at this position. A missing block
+// parameter means latest.
+var blockMethods = map[string]int{
+	"eth_getBlockByNumber":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"eth_call":                                1,
+	"eth_getBalance":                          1,
+	"eth_getCode":                             1,
+	"eth_getStorageAt":                        2,
+}
+
+type cacheScope int
+
+const (
+	scopeNone cacheScope = iota
+	scopeHead
+	scopeForever
+)
+
+// Scope of a block parameter given the current head
+func blockScope(param interface{}, head uint64) cacheScope {
+	if object, ok := param.(map[string]interface{}); ok {
+		if _, ok := object["blockHash"]; ok {
+			return scopeForever
+		}
+		param = object["blockNumber"]
+	}
+
+	switch param {
+	case "latest":
+		return scopeHead
+	case "earliest":
+		return scopeForever
+	case "pending", nil:
+		return scopeNone
+	}
+
+	number, err := parseBlockNumber(param)
+	if err != nil || number > head {
+		return scopeNone
+	}
+	if number+cacheFinalityDepth <= head {
+		return scopeForever
+	}
+	return scopeHead
+}
+
+func requestScope(jsr bt.JsRequest, head uint64) cacheScope {
+	if jsr.Validate() != nil {
+		return scopeNone
+	}
+	if immutableMethods[jsr.Method] {
+		return scopeForever
+	}
+	if headMethods[jsr.Method] {
+		return scopeHead
+	}
+	position, ok := blockMethods[jsr.Method]
+	if !ok {
+		return scopeNone
+	}
+	if position >= len(jsr.Params) {
+		return scopeHead
+	}
+	return blockScope(jsr.Params[position], head)
+}
+
+type cacheEntry struct {
+	result json.RawMessage
+	scope  cacheScope
+	head   uint64
+}
+
+// CachingProxy answers repeated idempotent reads without asking the
+// vanilla node. Results for the latest block are dropped on every new
+// head, results for final blocks are kept.
+type CachingProxy struct {
+	proxy   http.Handler
+	blocks  *BlockWatcher
+	mx      sync.Mutex
+	entries map[string]cacheEntry
+	size    int
+}
+
+func NewCachingProxy(proxy http.Handler, blocks *BlockWatcher) *CachingProxy {
+	c := &CachingProxy{
+		proxy:   proxy,
+		blocks:  blocks,
+		entries: map[string]cacheEntry{},
+	}
+	blocks.Subscribe(c.onBlock)
+	return c
+}
+
+func cacheKey(jsr bt.JsRequest) string {
+	params, _ := json.Marshal(jsr.Params)
+	if jsr.NamedParams != nil {
+		params = jsr.NamedParams
+	}
+	return jsr.Method + string(params)
+}
+
+func (c *CachingProxy) onBlock(head uint64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for key, entry := range c.entries {
+		if entry.scope == scopeHead {
+			c.remove(key, entry)
+		}
+	}
+}
+
+func entrySize(key string, result json.RawMessage) int {
+	return len(key) + len(result)
+}
+
+func (c *CachingProxy) remove(key string, entry cacheEntry) {
+	delete(c.entries, key)
+	c.size -= entrySize(key, entry.result)
+}
+
+func (c *CachingProxy) get(jsr bt.JsRequest, head uint64) (json.RawMessage, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	entry, ok := c.entries[cacheKey(jsr)]
+	if !ok || (entry.scope == scopeHead && entry.head != head) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+func (c *CachingProxy) put(jsr bt.JsRequest, scope cacheScope, head uint64, result json.RawMessage) {
+	// Missing blocks and transactions may appear later
+	if len(result) == 0 || string(result) == "null" {
+		return
+	}
+	key := cacheKey(jsr)
+	size := entrySize(key, result)
+	if size > cacheMaxEntryBytes {
+		return
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if existing, ok := c.entries[key]; ok {
+		c.remove(key, existing)
+	}
+	if c.full(size) {
+		c.evict(size)
+	}
+	c.entries[key] = cacheEntry{
+		result: result,
+		scope:  scope,
+		head:   head,
+	}
+	c.size += size
+}
+
+func (c *CachingProxy) full(size int) bool {
+	return len(c.entries) >= cacheMaxEntries || c.size+size > cacheMaxBytes
+}
+
+// Drop head scoped entries, then arbitrary ones until there is room for
+// an entry of size bytes
+func (c *CachingProxy) evict(size int) {
+	for key, entry := range c.entries {
+		if entry.scope == scopeHead {
+			c.remove(key, entry)
+		}
+	}
+	for key, entry := range c.entries {
+		if !c.full(size) {
+			return
+		}
+		c.remove(key, entry)
+	}
+}
+
+type upstreamResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Cache a successful response from the vanilla node
+func (c *CachingProxy) store(jsr bt.JsRequest, head uint64, body []byte) {
+	scope := requestScope(jsr, head)
+	if scope == scopeNone {
+		return
+	}
+	var response upstreamResponse
+	if json.Unmarshal(body, &response) != nil {
+		return
+	}
+	if len(response.Error) > 0 && string(response.Error) != "null" {
+		return
+	}
+	c.put(jsr, scope, head, response.Result)
+}
+
+func (c *CachingProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	head := c.blocks.Head()
+	batch, isBatch, err := bt.ParseBatch(body)
+	if err != nil || head == 0 {
+		c.proxy.ServeHTTP(res, req)
+		return
+	}
+
+	hits := map[int]json.RawMessage{}
+	misses := []bt.JsRequest{}
+	positions := map[int]int{}
+	cacheable := false
+	for i, jsr := range batch {
+		scope := requestScope(jsr, head)
+		if scope != scopeNone {
+			if result, ok := c.get(jsr, head); ok {
+				hits[i] = result
+				continue
+			}
+			cacheable = cacheable || !jsr.IsNotification()
+		}
+		positions[i] = len(misses)
+		misses = append(misses, jsr)
+	}
+
+	if len(hits) == 0 && !cacheable {
+		c.proxy.ServeHTTP(res, req)
+		return
+	}
+
+	if !isBatch {
+		if len(hits) > 0 {
+			if !batch[0].IsNotification() {
+				writeJSON(res, bt.NewJsResult(batch[0].ID, hits[0]))
+			}
+			return
+		}
+		recorder := newBufferedResponse()
+		c.proxy.ServeHTTP(recorder, req)
+		if recorder.status == http.StatusOK {
+			c.store(batch[0], head, recorder.body.Bytes())
+		}
+		for key, values := range recorder.header {
+			res.Header()[key] = values
+		}
+		res.WriteHeader(recorder.status)
+		res.Write(recorder.body.Bytes())
+		return
+	}
+
+	upstream := []json.RawMessage{}
+	if len(misses) > 0 {
+		upstream = c.forward(req, misses, head)
+	}
+
+	responses := []interface{}{}
+	for i, jsr := range batch {
+		if jsr.IsNotification() {
+			continue
+		}
+		if result, ok := hits[i]; ok {
+			responses = append(responses, bt.NewJsResult(jsr.ID, result))
+		} else if position := positions[i]; position < len(upstream) && upstream[position] != nil {
+			responses = append(responses, upstream[position])
+		} else {
+			jsErr := &bt.JsError{Code: bt.CodeInternalError, Message: "No response from vanilla node"}
+			responses = append(responses, errorResponse(jsr.ID, jsErr))
+		}
+	}
+	if len(responses) > 0 {
+		writeJSON(res, responses)
+	}
+}
+
+// Send the requests which missed the cache as a batch and cache their
+// results. Responses are in request order, nil where there was none.
+func (c *CachingProxy) forward(req *http.Request, misses []bt.JsRequest, head uint64) []json.RawMessage {
+	body, err := json.Marshal(renumber(misses))
+	if err != nil {
+		log.Printf("Encoding error %s", err)
+		return nil
+	}
+
+	proxyReq := req.Clone(req.Context())
+	proxyReq.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	proxyReq.ContentLength = int64(len(body))
+	recorder := newBufferedResponse()
+	c.proxy.ServeHTTP(recorder, proxyReq)
+
+	responses, err := restoreIDs(misses, recorder.body.Bytes())
+	if err != nil {
+		log.Printf("Invalid batch response from vanilla: %s\n", err)
+		return responses
+	}
+	for i, response := range responses {
+		if response != nil {
+			c.store(misses[i], head, response)
+		}
+	}
+	return responses
+}
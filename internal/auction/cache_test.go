@@ -0,0 +1,146 @@
+package auction
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Answers with the number of requests it has seen for the method
+type countingProxy struct {
+	calls map[string]int
+}
+
+func (c *countingProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	batch, isBatch, _ := bt.ParseBatch(body)
+	responses := []bt.JsResponse{}
+	for _, jsr := range batch {
+		c.calls[jsr.Method]++
+		var result interface{} = c.calls[jsr.Method]
+		if jsr.Method == "eth_getBlockByHash" {
+			result = nil
+		}
+		responses = append(responses, bt.NewJsResult(jsr.ID, result))
+	}
+	if isBatch {
+		json.NewEncoder(res).Encode(responses)
+	} else {
+		json.NewEncoder(res).Encode(responses[0])
+	}
+}
+
+func TestCachingProxy(t *testing.T) {
+	chain := &stubBlocks{head: 1000}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	upstream := &countingProxy{calls: map[string]int{}}
+	cache := NewCachingProxy(upstream, blocks)
+	request := func(body string) string {
+		res := httptest.NewRecorder()
+		cache.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return strings.TrimSpace(res.Body.String())
+	}
+	call := func(method string, params string, id int) string {
+		return `{"jsonrpc":"2.0","method":"` + method + `","params":` + params + `,"id":` + strconv.Itoa(id) + `}`
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		params   string
+		expected int
+	}{
+		{"immutable", "eth_chainId", `[]`, 1},
+		{"latest", "eth_call", `[{},"latest"]`, 1},
+		{"default block", "eth_getBalance", `["0x01"]`, 1},
+		{"final block", "eth_getBlockByNumber", `["0x1",false]`, 1},
+		{"pending", "eth_getStorageAt", `["0x01","0x0","pending"]`, 2},
+		{"future block", "eth_getCode", `["0x01","0x1000"]`, 2},
+		{"null result", "eth_getBlockByHash", `["0x01",false]`, 2},
+		{"not cacheable", "eth_getLogs", `[{}]`, 2},
+	}
+
+	for _, c := range cases {
+		first := request(call(c.method, c.params, 1))
+		second := request(call(c.method, c.params, 2))
+		if upstream.calls[c.method] != c.expected {
+			t.Errorf("%s: expected %d upstream calls, got %d", c.name, c.expected, upstream.calls[c.method])
+		}
+		if !strings.Contains(second, `"id":2`) {
+			t.Errorf("%s: expected the request id, got %s after %s", c.name, second, first)
+		}
+	}
+
+	// Latest results expire with the head, final ones don't
+	chain.head = 1001
+	blocks.poll()
+	batch := "[" + call("eth_call", `[{},"latest"]`, 3) + "," + call("eth_getBlockByNumber", `["0x1",false]`, 4) + "]"
+	got := request(batch)
+	if upstream.calls["eth_call"] != 2 || upstream.calls["eth_getBlockByNumber"] != 1 {
+		t.Errorf("unexpected upstream calls %v", upstream.calls)
+	}
+	expected := `[{"jsonrpc":"2.0","result":2,"id":3},{"jsonrpc":"2.0","result":1,"id":4}]`
+	if got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestCachingProxyDuplicateIDs(t *testing.T) {
+	chain := &stubBlocks{head: 1000}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+	cache := NewCachingProxy(&countingProxy{calls: map[string]int{}}, blocks)
+	request := func(body string) string {
+		res := httptest.NewRecorder()
+		cache.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return strings.TrimSpace(res.Body.String())
+	}
+
+	first := `{"jsonrpc":"2.0","method":"eth_getBalance","params":["0x01","latest"],"id":"a"}`
+	second := `{"jsonrpc":"2.0","method":"eth_getBalance","params":["0x02","latest"],"id":"a"}`
+	got := request("[" + first + "," + second + "]")
+	expected := `[{"jsonrpc":"2.0","result":1,"id":"a"},{"jsonrpc":"2.0","result":2,"id":"a"}]`
+	if got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	// Each result is cached for its own request
+	if got := request(second); got != `{"jsonrpc":"2.0","result":2,"id":"a"}` {
+		t.Errorf("expected the second result from the cache, got %s", got)
+	}
+}
+
+func TestCachingProxySize(t *testing.T) {
+	chain := &stubBlocks{head: 1000}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+	cache := NewCachingProxy(&countingProxy{calls: map[string]int{}}, blocks)
+
+	call := bt.JsRequest{JSONRPC: "2.0", Method: "eth_call", Params: []interface{}{"latest"}}
+	large := json.RawMessage(`"` + strings.Repeat("0", cacheMaxEntryBytes) + `"`)
+	cache.put(call, scopeHead, 1000, large)
+	if _, ok := cache.get(call, 1000); ok || cache.size != 0 {
+		t.Errorf("expected an oversized result not to be cached, size %d", cache.size)
+	}
+
+	cache.put(call, scopeHead, 1000, json.RawMessage(`"0x1"`))
+	cache.put(call, scopeHead, 1000, json.RawMessage(`"0x2"`))
+	if cache.size != entrySize(cacheKey(call), json.RawMessage(`"0x2"`)) {
+		t.Errorf("expected a replaced entry to be counted once, size %d", cache.size)
+	}
+
+	chain.head = 1001
+	blocks.poll()
+	if len(cache.entries) != 0 || cache.size != 0 {
+		t.Errorf("expected the head entries to be dropped, %d entries of %d bytes", len(cache.entries), cache.size)
+	}
+}
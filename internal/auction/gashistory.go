@@ -0,0 +1,152 @@
+package auction
+
+import (
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// GasRecord is a reading from one gas source
+type GasRecord struct {
+	Source      string                  `json:"source"`
+	Time        time.Time               `json:"time"`
+	Tiers       map[string]*hexutil.Big `json:"tiers"`
+	BaseFee     *hexutil.Big            `json:"baseFee,omitempty"`
+	PriorityFee *hexutil.Big            `json:"priorityFee,omitempty"`
+}
+
+// GasHistory keeps the most recent gas readings in a ring buffer and
+// optionally persists every reading to the store
+type GasHistory struct {
+	mx      sync.Mutex
+	records []GasRecord
+	next    int
+	size    int
+	store   st.Store
+}
+
+// store may be nil
+func NewGasHistory(size int, store st.Store) *GasHistory {
+	return &GasHistory{
+		records: []GasRecord{},
+		size:    size,
+		store:   store,
+	}
+}
+
+func decimal(i *big.Int) string {
+	if i == nil {
+		return ""
+	}
+	return i.String()
+}
+
+func (h *GasHistory) Record(source string, reading GasReading) {
+	record := GasRecord{
+		Source:      source,
+		Time:        reading.Time,
+		Tiers:       map[string]*hexutil.Big{},
+		BaseFee:     (*hexutil.Big)(reading.BaseFee),
+		PriorityFee: (*hexutil.Big)(reading.Tip),
+	}
+	entry := st.GasEntry{
+		Source:      source,
+		Tiers:       map[string]string{},
+		BaseFee:     decimal(reading.BaseFee),
+		PriorityFee: decimal(reading.Tip),
+		Timestamp:   reading.Time,
+	}
+	for tier, price := range reading.Tiers {
+		record.Tiers[tier] = (*hexutil.Big)(price)
+		entry.Tiers[tier] = decimal(price)
+	}
+
+	h.mx.Lock()
+	if len(h.records) < h.size {
+		h.records = append(h.records, record)
+	} else if h.size > 0 {
+		h.records[h.next] = record
+		h.next = (h.next + 1) % h.size
+	}
+	h.mx.Unlock()
+
+	if h.store != nil {
+		err := h.store.SaveGas(&entry)
+		if err != nil {
+			log.Printf("Failed to store gas reading: %s\n", err)
+		}
+	}
+}
+
+// Records returns at most limit of the latest readings, oldest first. A
+// limit of 0 returns every retained reading.
+func (h *GasHistory) Records(limit int) []GasRecord {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	records := make([]GasRecord, 0, len(h.records))
+	records = append(records, h.records[h.next:]...)
+	records = append(records, h.records[:h.next]...)
+	if limit > 0 && limit < len(records) {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// bukowskis_gasHistory takes an optional limit on the number of records
+func (h *GasHistory) Method() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		limit := 0
+		if len(jsr.Params) > 0 {
+			n, ok := jsr.Params[0].(float64)
+			if !ok || n < 0 {
+				return nil, &bt.JsError{
+					Code:    bt.CodeInvalidParams,
+					Message: "Invalid Request, limit should be a positive number",
+				}
+			}
+			limit = int(n)
+		}
+		return h.Records(limit), nil
+	}
+}
+
+type gasPriceResult struct {
+	GasPrice    *hexutil.Big            `json:"gasPrice,omitempty"`
+	PriorityFee *hexutil.Big            `json:"priorityFee,omitempty"`
+	BaseFee     *hexutil.Big            `json:"baseFee,omitempty"`
+	Tiers       map[string]*hexutil.Big `json:"tiers"`
+}
+
+// bukowskis_gasPrice reports the minimum fees enforced for the caller's
+// source along with the current tier prices
+func GasPriceMethod(gasGetter GasGetter, policies GasPolicies) MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		policy := policies.For(requestSource(req))
+		result := gasPriceResult{
+			Tiers: map[string]*hexutil.Big{},
+		}
+		for _, tier := range gasTiers {
+			if price := gasGetter.TierPrice(tier); price != nil {
+				result.Tiers[tier] = (*hexutil.Big)(price)
+			}
+		}
+
+		baseFee := gasGetter.BaseFee()
+		result.BaseFee = (*hexutil.Big)(baseFee)
+		if policy.Disabled {
+			return result, nil
+		}
+
+		result.GasPrice = (*hexutil.Big)(policy.MinGasPrice(gasGetter))
+		if minTip := gasGetter.TierPriorityFee(policy.tier()); minTip != nil && baseFee != nil {
+			result.PriorityFee = (*hexutil.Big)(policy.MinPriorityFee(minTip, baseFee))
+		}
+		return result, nil
+	}
+}
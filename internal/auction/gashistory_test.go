@@ -0,0 +1,134 @@
+package auction
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// blockingStore holds SaveGas until released
+type blockingStore struct {
+	*st.Local
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b blockingStore) SaveGas(entry *st.GasEntry) error {
+	b.saving <- struct{}{}
+	<-b.release
+	return b.Local.SaveGas(entry)
+}
+
+func TestGasHistoryRecords(t *testing.T) {
+	history := NewGasHistory(3, nil)
+	for i := int64(1); i <= 4; i++ {
+		history.Record("node", GasReading{
+			Price: big.NewInt(i),
+			Tiers: GasTiers{GasTierFast: big.NewInt(i)},
+			Time:  time.Unix(i, 0),
+		})
+	}
+
+	records := history.Records(0)
+	if len(records) != 3 || records[0].Time.Unix() != 2 || records[2].Time.Unix() != 4 {
+		t.Fatalf("expected the three latest readings oldest first, got %+v", records)
+	}
+	if records[2].Tiers[GasTierFast].ToInt().Int64() != 4 {
+		t.Errorf("expected the fast tier, got %v", records[2].Tiers)
+	}
+
+	method := history.Method()
+	result, err := method(nil, bt.JsRequest{Params: []interface{}{float64(2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := result.([]GasRecord); len(records) != 2 || records[0].Time.Unix() != 3 {
+		t.Errorf("expected the two latest readings, got %+v", records)
+	}
+	if _, err := method(nil, bt.JsRequest{Params: []interface{}{"2"}}); err == nil {
+		t.Error("expected a non-numeric limit to be refused")
+	}
+	if _, err := method(nil, bt.JsRequest{Params: []interface{}{float64(-1)}}); err == nil {
+		t.Error("expected a negative limit to be refused")
+	}
+}
+
+func TestGasPriceMethod(t *testing.T) {
+	gas := &MockGasGetter{
+		price:   big.NewInt(150),
+		tiers:   GasTiers{GasTierAverage: big.NewInt(120)},
+		baseFee: big.NewInt(100),
+		tip:     big.NewInt(10),
+	}
+	policies := GasPolicies{
+		"wallet": GasPolicy{Tier: GasTierAverage},
+		"free":   GasPolicy{Disabled: true},
+	}
+	method := GasPriceMethod(gas, policies)
+	price := func(source string) gasPriceResult {
+		req := httptest.NewRequest("POST", "/?source="+source, nil)
+		result, err := method(req, bt.JsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(gasPriceResult)
+	}
+
+	result := price("")
+	if result.GasPrice.ToInt().Int64() != 150 || result.PriorityFee.ToInt().Int64() != 10 || result.BaseFee.ToInt().Int64() != 100 {
+		t.Errorf("expected the fast price and the tip, got %+v", result)
+	}
+	if len(result.Tiers) != len(gasTiers) || result.Tiers[GasTierAverage].ToInt().Int64() != 120 {
+		t.Errorf("expected every tier, got %v", result.Tiers)
+	}
+	if result := price("wallet"); result.GasPrice.ToInt().Int64() != 120 {
+		t.Errorf("expected the source's tier, got %s", result.GasPrice)
+	}
+	if result := price("free"); result.GasPrice != nil || result.PriorityFee != nil || result.BaseFee == nil {
+		t.Errorf("expected no minimum for a disabled policy, got %+v", result)
+	}
+}
+
+func TestGasServiceRecordUnlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(res, `{"safeLow": 10, "average": 20, "fast": 30, "fastest": 40}`)
+	}))
+	defer server.Close()
+
+	g, err := NewGasService(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, _ := st.NewLocal()
+	store := blockingStore{local, make(chan struct{}), make(chan struct{})}
+	g.SetHistory(NewGasHistory(10, store))
+
+	done := make(chan struct{})
+	go func() {
+		g.updateGas()
+		close(done)
+	}()
+	<-store.saving
+
+	// Prices can be read while the reading is being stored
+	read := make(chan *big.Int)
+	go func() {
+		read <- g.FastPrice()
+	}()
+	select {
+	case price := <-read:
+		if price.Cmp(gasNowToWei(30)) != 0 {
+			t.Errorf("expected the fast price, got %s", price)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("price read blocked while the reading was stored")
+	}
+	close(store.release)
+	<-done
+}
@@ -0,0 +1,275 @@
+package auction
+
+import (
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// GasReading is a single observation from a gas source. Price is the fast
+// tier and Tip the fast tier's priority fee. Tiers, BaseFee, Tip and Tips
+// are nil when the source doesn't provide them.
+type GasReading struct {
+	Price   *big.Int
+	Tiers   GasTiers
+	BaseFee *big.Int
+	Tip     *big.Int
+	Tips    GasTiers
+	Time    time.Time
+}
+
+type GasSource interface {
+	Name() string
+	Poll() (GasReading, error)
+}
+
+type ethGasStationSource struct {
+	url string
+}
+
+func NewEthGasStationSource(url string) GasSource {
+	return &ethGasStationSource{url}
+}
+
+func (e *ethGasStationSource) Name() string {
+	return "ethgasstation"
+}
+
+func (e *ethGasStationSource) Poll() (GasReading, error) {
+	tiers, err := pollGasPrice(e.url)
+	if err != nil {
+		return GasReading{}, err
+	}
+	return GasReading{
+		Price: tiers[GasTierFast],
+		Tiers: tiers,
+		Time:  time.Now(),
+	}, nil
+}
+
+type nodeGasSource struct {
+	client *rpc.Client
+}
+
+func NewNodeGasSource(url string) (GasSource, error) {
+	client, err := rpc.DialHTTP(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewNodeGasSourceWithClient(client), nil
+}
+
+func NewNodeGasSourceWithClient(client *rpc.Client) GasSource {
+	return &nodeGasSource{client}
+}
+
+func (n *nodeGasSource) Name() string {
+	return "node"
+}
+
+func (n *nodeGasSource) Poll() (GasReading, error) {
+	reading, err := pollNodeGasPrice(n.client)
+	if err != nil {
+		return GasReading{}, err
+	}
+	return GasReading{
+		Price:   reading.price,
+		Tiers:   reading.tiers,
+		BaseFee: reading.baseFee,
+		Tip:     reading.tip,
+		Tips:    reading.tips,
+		Time:    time.Now(),
+	}, nil
+}
+
+// Readings further than this factor from the median are discarded
+const gasOutlierFactor = 2
+
+// With fewer readings there's no telling which one is the outlier
+const gasMinOutlierReadings = 3
+
+// CompositeGasService polls several sources and reports the median of the
+// fresh, non-outlying readings. The floor is used as a lower bound and as
+// the price when no source has a usable reading, so FastPrice never
+// returns nil.
+type CompositeGasService struct {
+	sources  []GasSource
+	floor    *big.Int
+	maxAge   time.Duration
+	interval time.Duration
+	mx       sync.Mutex
+	fin      chan interface{}
+	readings map[string]GasReading
+	history  *GasHistory
+}
+
+func NewCompositeGasService(
+	sources []GasSource,
+	floor *big.Int,
+	interval time.Duration,
+	maxAge time.Duration) *CompositeGasService {
+	c := &CompositeGasService{
+		sources:  sources,
+		floor:    floor,
+		maxAge:   maxAge,
+		interval: interval,
+		fin:      make(chan interface{}),
+		readings: map[string]GasReading{},
+	}
+	c.updateGas()
+	return c
+}
+
+// Record every subsequent reading from each source in the history
+func (c *CompositeGasService) SetHistory(history *GasHistory) {
+	c.history = history
+}
+
+func (c *CompositeGasService) Run() {
+	log.Println("running composite gas service")
+	timer := time.NewTicker(c.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			c.updateGas()
+		case <-c.fin:
+			return
+		}
+	}
+}
+
+func (c *CompositeGasService) updateGas() {
+	var wg sync.WaitGroup
+	for _, source := range c.sources {
+		wg.Add(1)
+		go func(source GasSource) {
+			defer wg.Done()
+			reading, err := source.Poll()
+			if err != nil {
+				log.Printf("Failed to poll %s gas: %s\n", source.Name(), err)
+				return
+			}
+			if reading.Price == nil {
+				log.Printf("No %s gas price\n", source.Name())
+				return
+			}
+
+			c.mx.Lock()
+			c.readings[source.Name()] = reading
+			c.mx.Unlock()
+
+			if c.history != nil {
+				c.history.Record(source.Name(), reading)
+			}
+		}(source)
+	}
+	wg.Wait()
+}
+
+func (c *CompositeGasService) Stop() {
+	close(c.fin)
+}
+
+// Readings younger than maxAge
+func (c *CompositeGasService) fresh() []GasReading {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	readings := []GasReading{}
+	for _, reading := range c.readings {
+		if time.Since(reading.Time) <= c.maxAge {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
+
+// Median of the values after discarding outliers, nil if there are none.
+// Too few values to pick out an outlier are only used if they agree, nil
+// is returned otherwise.
+func robustMedian(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return nil
+	}
+
+	mid := median(values)
+	upper := new(big.Int).Mul(mid, big.NewInt(gasOutlierFactor))
+	kept := []*big.Int{}
+	for _, v := range values {
+		lower := new(big.Int).Mul(v, big.NewInt(gasOutlierFactor))
+		if v.Cmp(upper) <= 0 && lower.Cmp(mid) >= 0 {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) < len(values) && len(values) < gasMinOutlierReadings {
+		return nil
+	}
+	return median(kept)
+}
+
+func (c *CompositeGasService) FastPrice() *big.Int {
+	prices := []*big.Int{}
+	for _, reading := range c.fresh() {
+		prices = append(prices, reading.Price)
+	}
+
+	price := robustMedian(prices)
+	if price == nil || price.Cmp(c.floor) == -1 {
+		return c.floor
+	}
+	return price
+}
+
+// Sources without tiers are left out. The floor applies to every tier.
+func (c *CompositeGasService) TierPrice(tier string) *big.Int {
+	if tier == GasTierFast {
+		return c.FastPrice()
+	}
+
+	prices := []*big.Int{}
+	for _, reading := range c.fresh() {
+		if price, ok := reading.Tiers[tier]; ok && price != nil {
+			prices = append(prices, price)
+		}
+	}
+
+	price := robustMedian(prices)
+	if price == nil || price.Cmp(c.floor) == -1 {
+		return c.floor
+	}
+	return price
+}
+
+func (c *CompositeGasService) BaseFee() *big.Int {
+	fees := []*big.Int{}
+	for _, reading := range c.fresh() {
+		if reading.BaseFee != nil {
+			fees = append(fees, reading.BaseFee)
+		}
+	}
+	return robustMedian(fees)
+}
+
+func (c *CompositeGasService) PriorityFee() *big.Int {
+	tips := []*big.Int{}
+	for _, reading := range c.fresh() {
+		if reading.Tip != nil {
+			tips = append(tips, reading.Tip)
+		}
+	}
+	return robustMedian(tips)
+}
+
+// Sources without tiered priority fees are left out
+func (c *CompositeGasService) TierPriorityFee(tier string) *big.Int {
+	tips := []*big.Int{}
+	for _, reading := range c.fresh() {
+		if tip, ok := reading.Tips[tier]; ok && tip != nil {
+			tips = append(tips, tip)
+		}
+	}
+	return robustMedian(tips)
+}
@@ -0,0 +1,113 @@
+package auction
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type stubGasSource struct {
+	name    string
+	reading GasReading
+	err     error
+}
+
+func (s *stubGasSource) Name() string {
+	return s.name
+}
+
+func (s *stubGasSource) Poll() (GasReading, error) {
+	return s.reading, s.err
+}
+
+func TestCompositeGasService(t *testing.T) {
+	now := time.Now()
+	reading := func(price int64, age time.Duration) GasReading {
+		return GasReading{Price: big.NewInt(price), Time: now.Add(-age)}
+	}
+
+	cases := []struct {
+		name     string
+		sources  []GasSource
+		expected int64
+	}{
+		{
+			name: "median of sources",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(120, 0)},
+				&stubGasSource{name: "c", reading: reading(110, 0)},
+			},
+			expected: 110,
+		},
+		{
+			name: "outlier discarded",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(105, 0)},
+				&stubGasSource{name: "c", reading: reading(10000, 0)},
+			},
+			expected: 102,
+		},
+		{
+			name: "mean of two sources",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(150, 0)},
+			},
+			expected: 125,
+		},
+		{
+			name: "inflated one of two sources falls back to floor",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(10000, 0)},
+			},
+			expected: 50,
+		},
+		{
+			name: "outlier of four discarded",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(105, 0)},
+				&stubGasSource{name: "c", reading: reading(110, 0)},
+				&stubGasSource{name: "d", reading: reading(10000, 0)},
+			},
+			expected: 105,
+		},
+		{
+			name: "stale reading ignored",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(100, 0)},
+				&stubGasSource{name: "b", reading: reading(500, time.Hour)},
+			},
+			expected: 100,
+		},
+		{
+			name: "failed sources fall back to floor",
+			sources: []GasSource{
+				&stubGasSource{name: "a", err: fmt.Errorf("unreachable")},
+				&stubGasSource{name: "b", reading: GasReading{Time: now}},
+			},
+			expected: 50,
+		},
+		{
+			name: "floor is a lower bound",
+			sources: []GasSource{
+				&stubGasSource{name: "a", reading: reading(10, 0)},
+			},
+			expected: 50,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := NewCompositeGasService(c.sources, big.NewInt(50), time.Minute, time.Minute)
+			price := service.FastPrice()
+			if price == nil || price.Cmp(big.NewInt(c.expected)) != 0 {
+				t.Errorf("expected %d got %v", c.expected, price)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package auction
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Derive the fast price from the vanilla node instead of a third party
+const (
+	feeHistoryBlocks = 20
+	nodeGasTimeout   = 10 * time.Second
+)
+
+// Tip percentiles requested from eth_feeHistory for each of gasTiers
+var feeHistoryPercentiles = []float64{10, 50, 90, 99}
+
+type feeHistoryResult struct {
+	OldestBlock *hexutil.Big     `json:"oldestBlock"`
+	BaseFee     []*hexutil.Big   `json:"baseFeePerGas"`
+	Reward      [][]*hexutil.Big `json:"reward"`
+}
+
+// A single reading from the node. tip is the fast tier's priority fee.
+// baseFee, tip and tips are nil when the node doesn't support
+// eth_feeHistory.
+type nodeGasReading struct {
+	price   *big.Int
+	tiers   GasTiers
+	baseFee *big.Int
+	tip     *big.Int
+	tips    GasTiers
+}
+
+// Each tier is the next block's base fee plus the median of recent tips at
+// the tier's percentile. The fast and fastest tiers are at least
+// eth_gasPrice. Nodes without eth_feeHistory fall back to eth_gasPrice
+// for every tier.
+func pollNodeGasPrice(client *rpc.Client) (nodeGasReading, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeGasTimeout)
+	defer cancel()
+
+	var gasPrice hexutil.Big
+	err := client.CallContext(ctx, &gasPrice, "eth_gasPrice")
+	if err != nil {
+		return nodeGasReading{}, fmt.Errorf("Failed to get eth_gasPrice: %s", err)
+	}
+	reading := nodeGasReading{
+		price: gasPrice.ToInt(),
+		tiers: GasTiers{},
+	}
+	for _, tier := range gasTiers {
+		reading.tiers[tier] = gasPrice.ToInt()
+	}
+
+	var history feeHistoryResult
+	err = client.CallContext(
+		ctx,
+		&history,
+		"eth_feeHistory",
+		hexutil.Uint64(feeHistoryBlocks),
+		"latest",
+		feeHistoryPercentiles)
+	if err != nil {
+		log.Printf("eth_feeHistory unavailable, using eth_gasPrice: %s\n", err)
+		return reading, nil
+	}
+
+	if len(history.BaseFee) == 0 {
+		return reading, nil
+	}
+
+	// The last base fee is for the block after the newest in the range
+	reading.baseFee = history.BaseFee[len(history.BaseFee)-1].ToInt()
+	reading.tips = GasTiers{}
+	for i, tier := range gasTiers {
+		tips := []*big.Int{}
+		for _, rewards := range history.Reward {
+			if len(rewards) > i {
+				tips = append(tips, rewards[i].ToInt())
+			}
+		}
+
+		reading.tips[tier] = median(tips)
+		price := new(big.Int).Add(reading.baseFee, reading.tips[tier])
+		if tier == GasTierFast || tier == GasTierFastest {
+			if price.Cmp(reading.price) == -1 {
+				price = reading.price
+			}
+		}
+		reading.tiers[tier] = price
+	}
+	reading.price = reading.tiers[GasTierFast]
+	reading.tip = reading.tips[GasTierFast]
+
+	return reading, nil
+}
+
+// The mean of the two middle values when there is an even number, so
+// neither of them alone sets the price
+func median(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return big.NewInt(0)
+	}
+	sorted := make([]*big.Int, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) == -1
+	})
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	sum := new(big.Int).Add(sorted[mid-1], sorted[mid])
+	return sum.Div(sum, big.NewInt(2))
+}
+
+type NodeGasService struct {
+	client  *rpc.Client
+	mx      sync.Mutex
+	fin     chan interface{}
+	reading nodeGasReading
+	history *GasHistory
+}
+
+func NewNodeGasService(url string) (*NodeGasService, error) {
+	client, err := rpc.DialHTTP(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewNodeGasServiceWithClient(client)
+}
+
+// Use an existing client, such as one from an UpstreamPool
+func NewNodeGasServiceWithClient(client *rpc.Client) (*NodeGasService, error) {
+	reading, err := pollNodeGasPrice(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeGasService{
+		client:  client,
+		fin:     make(chan interface{}),
+		reading: reading,
+	}, nil
+}
+
+// Record every subsequent reading in the history
+func (n *NodeGasService) SetHistory(history *GasHistory) {
+	n.history = history
+}
+
+func (n *NodeGasService) Run() {
+	log.Println("running node gas service")
+	timer := time.NewTicker(15 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			n.updateGas()
+		case <-n.fin:
+			return
+		}
+	}
+}
+
+func (n *NodeGasService) updateGas() {
+	reading, err := pollNodeGasPrice(n.client)
+	if err != nil {
+		log.Printf("Failed to update min gas %s\n", err)
+		return
+	}
+
+	n.mx.Lock()
+	n.reading = reading
+	n.mx.Unlock()
+
+	if n.history != nil {
+		n.history.Record("node", GasReading{
+			Price:   reading.price,
+			Tiers:   reading.tiers,
+			BaseFee: reading.baseFee,
+			Tip:     reading.tip,
+			Tips:    reading.tips,
+			Time:    time.Now(),
+		})
+	}
+}
+
+func (n *NodeGasService) Stop() {
+	close(n.fin)
+	n.client.Close()
+}
+
+func (n *NodeGasService) FastPrice() *big.Int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.reading.price
+}
+
+func (n *NodeGasService) TierPrice(tier string) *big.Int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.reading.tiers[tier]
+}
+
+func (n *NodeGasService) BaseFee() *big.Int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.reading.baseFee
+}
+
+func (n *NodeGasService) PriorityFee() *big.Int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.reading.tip
+}
+
+func (n *NodeGasService) TierPriorityFee(tier string) *big.Int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.reading.tips[tier]
+}
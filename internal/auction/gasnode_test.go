@@ -0,0 +1,116 @@
+package auction
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+type stubRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+}
+
+// Serve canned results keyed by method, or a method not found error
+func stubNode(t *testing.T, results map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var jsr stubRequest
+		if err := json.NewDecoder(req.Body).Decode(&jsr); err != nil {
+			t.Fatalf("Invalid request: %s", err)
+		}
+
+		response := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      jsr.ID,
+		}
+		if result, ok := results[jsr.Method]; ok {
+			response["result"] = result
+		} else {
+			response["error"] = bt.JsError{Code: -32601, Message: "method not found"}
+		}
+		json.NewEncoder(res).Encode(response)
+	}))
+}
+
+func TestNodeGasService(t *testing.T) {
+	cases := []struct {
+		name     string
+		results  map[string]interface{}
+		expected int64
+		// The fastest tier's priority fee, 0 if there is none
+		fastestTip int64
+	}{
+		{
+			name: "gas price only",
+			results: map[string]interface{}{
+				"eth_gasPrice": "0x64",
+			},
+			expected: 100,
+		},
+		{
+			name: "fee history above gas price",
+			results: map[string]interface{}{
+				"eth_gasPrice": "0x64",
+				"eth_feeHistory": map[string]interface{}{
+					"oldestBlock":   "0x1",
+					"baseFeePerGas": []string{"0x50", "0x5a", "0x78"},
+					"reward":        [][]string{{"0x1", "0x5", "0xa", "0x14"}, {"0x2", "0x6", "0x1e", "0x28"}},
+				},
+			},
+			expected:   140,
+			fastestTip: 30,
+		},
+		{
+			name: "gas price above fee history",
+			results: map[string]interface{}{
+				"eth_gasPrice": "0xc8",
+				"eth_feeHistory": map[string]interface{}{
+					"oldestBlock":   "0x1",
+					"baseFeePerGas": []string{"0x50", "0x5a"},
+					"reward":        [][]string{{"0x1", "0x5", "0xa", "0x14"}},
+				},
+			},
+			expected:   200,
+			fastestTip: 20,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node := stubNode(t, c.results)
+			defer node.Close()
+
+			service, err := NewNodeGasService(node.URL)
+			if err != nil {
+				t.Fatalf("Failed to create service: %s", err)
+			}
+
+			if service.FastPrice().Cmp(big.NewInt(c.expected)) != 0 {
+				t.Errorf("expected %d got %d", c.expected, service.FastPrice())
+			}
+
+			_, hasHistory := c.results["eth_feeHistory"]
+			if hasHistory != (service.BaseFee() != nil) {
+				t.Errorf("unexpected base fee %v", service.BaseFee())
+			}
+			tip := service.TierPriorityFee(GasTierFastest)
+			if (c.fastestTip == 0) != (tip == nil) || (tip != nil && tip.Int64() != c.fastestTip) {
+				t.Errorf("expected the fastest tip %d, got %v", c.fastestTip, tip)
+			}
+		})
+	}
+}
+
+func TestNodeGasServiceNoGasPrice(t *testing.T) {
+	node := stubNode(t, map[string]interface{}{})
+	defer node.Close()
+
+	_, err := NewNodeGasService(node.URL)
+	if err == nil {
+		t.Fatal("expected error without eth_gasPrice")
+	}
+}
@@ -14,15 +14,47 @@ import (
 // Create an intergration with GasNow
 // https://ethgasstation.info/json/ethgasAPI.json
 
+// Price tiers, from cheapest to most expensive
+const (
+	GasTierSafeLow = "safeLow"
+	GasTierAverage = "average"
+	GasTierFast    = "fast"
+	GasTierFastest = "fastest"
+)
+
+var gasTiers = []string{GasTierSafeLow, GasTierAverage, GasTierFast, GasTierFastest}
+
+// GasTiers maps a tier to its price in wei
+type GasTiers map[string]*big.Int
+
 type GasGetter interface {
 	FastPrice() *big.Int
+	// Price of the given tier, nil if unknown
+	TierPrice(tier string) *big.Int
+	// Base fee of the next block, nil if unknown
+	BaseFee() *big.Int
+	// Minimum priority fee for EIP-1559 transactions, nil if unknown
+	PriorityFee() *big.Int
+	// Priority fee of the given tier, nil if unknown
+	TierPriorityFee(tier string) *big.Int
 }
 
+// ethgasstation reports prices in units of 0.1 gwei
+const gasNowUnit = 1e8
+
 type gasNowResponse struct {
-	Fast float64 `json:fast`
+	SafeLow float64 `json:"safeLow"`
+	Average float64 `json:"average"`
+	Fast    float64 `json:"fast"`
+	Fastest float64 `json:"fastest"`
 }
 
-func pollGasPrice(url string) (*big.Int, error) {
+func gasNowToWei(price float64) *big.Int {
+	k := math.Round(price)
+	return new(big.Int).Mul(big.NewInt(int64(k)), big.NewInt(gasNowUnit))
+}
+
+func pollGasPrice(url string) (GasTiers, error) {
 	client := http.Client{}
 
 	resp, err := client.Get(url)
@@ -37,22 +69,26 @@ func pollGasPrice(url string) (*big.Int, error) {
 		return nil, fmt.Errorf("Failed to decode response body: %s", err)
 	}
 
-	k := math.Round(res.Fast)
-	i := big.NewInt(int64(k))
-	return i, nil
+	return GasTiers{
+		GasTierSafeLow: gasNowToWei(res.SafeLow),
+		GasTierAverage: gasNowToWei(res.Average),
+		GasTierFast:    gasNowToWei(res.Fast),
+		GasTierFastest: gasNowToWei(res.Fastest),
+	}, nil
 }
 
 type GasService struct {
-	url   string
-	mx    sync.Mutex // XXX: Switch to RWLock
-	fin   chan interface{}
-	price *big.Int
+	url     string
+	mx      sync.Mutex // XXX: Switch to RWLock
+	fin     chan interface{}
+	tiers   GasTiers
+	history *GasHistory
 }
 
 // XXX: Might want to seperate construction from intialization
 // Return error
 func NewGasService(url string) (*GasService, error) {
-	price, err := pollGasPrice(url)
+	tiers, err := pollGasPrice(url)
 
 	if err != nil {
 		return nil, err
@@ -60,10 +96,15 @@ func NewGasService(url string) (*GasService, error) {
 	return &GasService{
 		url:   url,
 		fin:   make(chan interface{}),
-		price: price,
+		tiers: tiers,
 	}, nil
 }
 
+// Record every subsequent reading in the history
+func (g *GasService) SetHistory(history *GasHistory) {
+	g.history = history
+}
+
 func (g *GasService) Run() {
 	log.Println("running gas service")
 	timer := time.NewTicker(60 * time.Second) // XXX: Configure
@@ -79,16 +120,26 @@ func (g *GasService) Run() {
 }
 
 func (g *GasService) updateGas() {
-	g.mx.Lock()
-	defer g.mx.Unlock()
-	price, err := pollGasPrice(g.url)
+	tiers, err := pollGasPrice(g.url)
 	if err != nil {
+		// Keep the last good price
 		log.Printf("Failed to update min gas %s\n", err)
+		return
 	}
 
-	log.Printf("New gas %d\n", price) // XXX: Remove
+	log.Printf("New gas %d\n", tiers[GasTierFast]) // XXX: Remove
 
-	g.price = price
+	g.mx.Lock()
+	g.tiers = tiers
+	g.mx.Unlock()
+
+	if g.history != nil {
+		g.history.Record("ethgasstation", GasReading{
+			Price: tiers[GasTierFast],
+			Tiers: tiers,
+			Time:  time.Now(),
+		})
+	}
 }
 
 func (g *GasService) Stop() {
@@ -96,15 +147,58 @@ func (g *GasService) Stop() {
 }
 
 func (g *GasService) FastPrice() *big.Int {
+	return g.TierPrice(GasTierFast)
+}
+
+func (g *GasService) TierPrice(tier string) *big.Int {
 	g.mx.Lock()
 	defer g.mx.Unlock()
-	return g.price
+	return g.tiers[tier]
+}
+
+// ethgasstation has no notion of base fee
+func (g *GasService) BaseFee() *big.Int {
+	return nil
+}
+
+func (g *GasService) PriorityFee() *big.Int {
+	return nil
+}
+
+func (g *GasService) TierPriorityFee(tier string) *big.Int {
+	return nil
 }
 
 type MockGasGetter struct {
-	price *big.Int
+	price   *big.Int
+	tiers   GasTiers
+	baseFee *big.Int
+	tip     *big.Int
+	tips    GasTiers
 }
 
 func (m *MockGasGetter) FastPrice() *big.Int {
 	return m.price
 }
+
+func (m *MockGasGetter) TierPrice(tier string) *big.Int {
+	if price, ok := m.tiers[tier]; ok {
+		return price
+	}
+	return m.price
+}
+
+func (m *MockGasGetter) BaseFee() *big.Int {
+	return m.baseFee
+}
+
+func (m *MockGasGetter) PriorityFee() *big.Int {
+	return m.tip
+}
+
+func (m *MockGasGetter) TierPriorityFee(tier string) *big.Int {
+	if tip, ok := m.tips[tier]; ok {
+		return tip
+	}
+	return m.tip
+}
@@ -0,0 +1,143 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// GasPolicy controls the minimum fee a source's transactions must pay.
+// Tier selects the price legacy transactions are compared against,
+// defaulting to fast. Dynamic fee transactions must instead pay the
+// tier's priority fee on top of the base fee. The multiplier scales the
+// price or priority fee, then Floor and Ceiling bound the resulting gas
+// price in wei.
+type GasPolicy struct {
+	Disabled   bool     `json:"disabled"`
+	Tier       string   `json:"tier"`
+	Multiplier float64  `json:"multiplier"`
+	Floor      *big.Int `json:"floor"`
+	Ceiling    *big.Int `json:"ceiling"`
+}
+
+func (p GasPolicy) tier() string {
+	if p.Tier == "" {
+		return GasTierFast
+	}
+	return p.Tier
+}
+
+func (p GasPolicy) scale(price *big.Int) *big.Int {
+	if price == nil || p.Multiplier <= 0 {
+		return price
+	}
+	scaled := new(big.Float).Mul(new(big.Float).SetInt(price), big.NewFloat(p.Multiplier))
+	result, _ := scaled.Int(nil)
+	return result
+}
+
+// The minimum gas price for legacy transactions, nil if there is none
+func (p GasPolicy) MinGasPrice(gasGetter GasGetter) *big.Int {
+	price := p.scale(gasGetter.TierPrice(p.tier()))
+	if p.Floor != nil && (price == nil || price.Cmp(p.Floor) == -1) {
+		price = p.Floor
+	}
+	if p.Ceiling != nil && price != nil && price.Cmp(p.Ceiling) == 1 {
+		price = p.Ceiling
+	}
+	return price
+}
+
+// The minimum priority fee for dynamic fee transactions at the base fee
+func (p GasPolicy) MinPriorityFee(minTip *big.Int, baseFee *big.Int) *big.Int {
+	tip := p.scale(minTip)
+	price := new(big.Int).Add(baseFee, tip)
+	if p.Floor != nil && price.Cmp(p.Floor) == -1 {
+		tip = new(big.Int).Sub(p.Floor, baseFee)
+	}
+	if p.Ceiling != nil && price.Cmp(p.Ceiling) == 1 {
+		tip = new(big.Int).Sub(p.Ceiling, baseFee)
+		if tip.Sign() == -1 {
+			tip = big.NewInt(0)
+		}
+	}
+	return tip
+}
+
+// Admission errors carry the price the transaction needed to pay
+func feeError(message string, field string, required *big.Int) error {
+	return &bt.JsError{
+		Code:    bt.CodeTransactionRejected,
+		Message: fmt.Sprintf("%s: requires at least %s wei", message, required),
+		Data: map[string]*hexutil.Big{
+			field: (*hexutil.Big)(required),
+		},
+	}
+}
+
+// Legacy transactions, and dynamic fee transactions when the base fee is
+// unknown, are compared against the policy's gas price
+func (p GasPolicy) Check(tx *types.Transaction, gasGetter GasGetter) error {
+	if p.Disabled {
+		return nil
+	}
+
+	baseFee := gasGetter.BaseFee()
+	minTip := gasGetter.TierPriorityFee(p.tier())
+	if tx.Type() == types.DynamicFeeTxType && baseFee != nil && minTip != nil {
+		if tx.GasFeeCapIntCmp(baseFee) == -1 {
+			return feeError("Fee cap below base fee", "requiredFeeCap", baseFee)
+		}
+		required := p.MinPriorityFee(minTip, baseFee)
+		if tx.EffectiveGasTipIntCmp(required, baseFee) == -1 {
+			return feeError("Priority fee too low", "requiredPriorityFee", required)
+		}
+		return nil
+	}
+
+	minGas := p.MinGasPrice(gasGetter)
+	if minGas != nil && tx.GasPrice().Cmp(minGas) == -1 {
+		return feeError("Gas too low", "requiredGasPrice", minGas)
+	}
+	return nil
+}
+
+// GasPolicies maps a transaction source to its gas policy
+type GasPolicies map[string]GasPolicy
+
+// Load policies from a JSON file of the form
+// {"default": {"tier": "fast"}, "wallet": {"tier": "average", "multiplier": 1.1}}
+func LoadGasPolicies(path string) (GasPolicies, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies GasPolicies
+	err = json.Unmarshal(contents, &policies)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode gas policies: %s", err)
+	}
+
+	for source, policy := range policies {
+		switch policy.Tier {
+		case "", GasTierSafeLow, GasTierAverage, GasTierFast, GasTierFastest:
+		default:
+			return nil, fmt.Errorf("Unknown gas tier %q for %s", policy.Tier, source)
+		}
+	}
+
+	return policies, nil
+}
+
+func (g GasPolicies) For(source string) GasPolicy {
+	if policy, ok := g[source]; ok {
+		return policy
+	}
+	return g[defaultSource]
+}
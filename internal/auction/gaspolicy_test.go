@@ -0,0 +1,72 @@
+package auction
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestGasPolicyCheck(t *testing.T) {
+	legacy := func(price int64) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{GasPrice: big.NewInt(price)})
+	}
+	dynamic := func(tipCap, feeCap int64) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{
+			GasTipCap: big.NewInt(tipCap),
+			GasFeeCap: big.NewInt(feeCap),
+		})
+	}
+	london := &MockGasGetter{
+		price:   big.NewInt(150),
+		baseFee: big.NewInt(100),
+		tip:     big.NewInt(10),
+		tips:    GasTiers{GasTierSafeLow: big.NewInt(2), GasTierFastest: big.NewInt(30)},
+	}
+	preLondon := &MockGasGetter{
+		price: big.NewInt(150),
+		tiers: GasTiers{GasTierAverage: big.NewInt(120)},
+	}
+	defaults := GasPolicy{}
+
+	cases := []struct {
+		name   string
+		tx     *types.Transaction
+		gas    GasGetter
+		policy GasPolicy
+		valid  bool
+	}{
+		{"legacy above fast price", legacy(150), london, defaults, true},
+		{"legacy below fast price", legacy(149), london, defaults, false},
+		{"dynamic tip above floor", dynamic(10, 200), london, defaults, true},
+		{"dynamic tip capped by fee cap", dynamic(50, 105), london, defaults, false},
+		{"dynamic fee cap below base fee", dynamic(50, 90), london, defaults, false},
+		{"dynamic tip below floor", dynamic(5, 200), london, defaults, false},
+		{"dynamic tip above safe low tier", dynamic(2, 200), london, GasPolicy{Tier: GasTierSafeLow}, true},
+		{"dynamic tip below fastest tier", dynamic(20, 200), london, GasPolicy{Tier: GasTierFastest}, false},
+		{"dynamic without base fee uses fee cap", dynamic(1, 150), preLondon, defaults, true},
+		{"dynamic without base fee below fast", dynamic(1, 149), preLondon, defaults, false},
+		{"disabled", legacy(1), london, GasPolicy{Disabled: true}, true},
+		{"average tier", legacy(120), preLondon, GasPolicy{Tier: GasTierAverage}, true},
+		{"below average tier", legacy(119), preLondon, GasPolicy{Tier: GasTierAverage}, false},
+		{"multiplier", legacy(150), london, GasPolicy{Multiplier: 1.1}, false},
+		{"multiplier met", legacy(165), london, GasPolicy{Multiplier: 1.1}, true},
+		{"floor", legacy(150), london, GasPolicy{Floor: big.NewInt(200)}, false},
+		{"ceiling", legacy(100), london, GasPolicy{Ceiling: big.NewInt(100)}, true},
+		{"dynamic multiplier", dynamic(15, 200), london, GasPolicy{Multiplier: 2}, false},
+		{"dynamic floor", dynamic(10, 200), london, GasPolicy{Floor: big.NewInt(130)}, false},
+		{"dynamic ceiling", dynamic(2, 200), london, GasPolicy{Ceiling: big.NewInt(102)}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.policy.Check(c.tx, c.gas)
+			if c.valid && err != nil {
+				t.Errorf("expected valid, got %s", err)
+			}
+			if !c.valid && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -1,103 +1,637 @@
 package auction
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/nukowsk/bukowskis/internal/sender"
-	st "github.com/nukowsk/bukowskis/internal/store"
 	bt "github.com/nukowsk/bukowskis/internal/types"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// MethodFunc answers an intercepted JSON-RPC method instead of proxying it
+// to the vanilla node
+type MethodFunc func(req *http.Request, jsr bt.JsRequest) (interface{}, error)
+
 type Handler struct {
 	proxy     http.Handler
-	processTx func(*types.Transaction) (string, error)
+	checkTx   func(*types.Transaction, string) error
+	processTx func(*types.Transaction, string, *Rebate) (string, error)
+	deliverTx func(*types.Transaction, string) (string, error)
+	methods   map[string]MethodFunc
+	sends     map[string]bool
+	limiter   *RateLimiter
+	policy    MethodPolicy
 }
 
+// pool may be nil to deliver every transaction as soon as it is accepted
 func NewHandler(
 	gasGetter GasGetter,
-	store st.Store,
-	sender sender.Sender,
-	proxy http.Handler) *Handler {
-	processTx := genProcessTx(gasGetter, store, sender)
-	return &Handler{
+	tracker *StatusTracker,
+	txSender sender.Sender,
+	proxy http.Handler,
+	hints HintPolicies,
+	gasPolicies GasPolicies,
+	validator Validator,
+	limiter *RateLimiter,
+	pool *PendingPool) *Handler {
+	// Queued senders report deliveries once they have actually been sent
+	async, ok := txSender.(sender.AsyncSender)
+	if ok {
+		async.SetListener(tracker)
+	}
+	deliverTx := genDeliverTx(txSender, hints, tracker, ok)
+	checkTx := genCheckTx(gasGetter, gasPolicies, validator)
+	processTx := genProcessTx(checkTx, tracker, deliverTx, limiter, pool)
+	h := &Handler{
 		proxy:     proxy,
+		checkTx:   checkTx,
 		processTx: processTx,
+		deliverTx: deliverTx,
+		methods:   map[string]MethodFunc{},
+		sends:     map[string]bool{},
+		limiter:   limiter,
+		policy:    DefaultMethodPolicy(),
+	}
+	// Re-deliveries wait for any nonce gap like the first delivery
+	if pool != nil {
+		pool.setDeliver(deliverTx)
+		h.deliverTx = pool.Redeliver
+	}
+	h.RegisterSend("eth_sendRawTransaction", h.sendTransaction)
+	h.RegisterSend("eth_sendTransaction", h.sendTransaction)
+	h.RegisterSend("eth_sendRawTransaction_reserve", h.sendTransaction)
+	h.RegisterSend("eth_sendTransaction_reserve", h.sendTransaction)
+	return h
+}
+
+// ProcessTransaction runs a transaction through admission and delivery
+// as if it had been sent by the source. rebate may be nil.
+func (h *Handler) ProcessTransaction(tx *types.Transaction, source string, rebate *Rebate) (string, error) {
+	return h.processTx(tx, source, rebate)
+}
+
+// CheckTransaction runs the admission checks without recording or
+// delivering the transaction
+func (h *Handler) CheckTransaction(tx *types.Transaction, source string) error {
+	return h.checkTx(tx, source)
+}
+
+// DeliverTransaction sends an already admitted transaction to the winner
+// again, unless the pool is still holding it behind a nonce gap
+func (h *Handler) DeliverTransaction(tx *types.Transaction, source string) (string, error) {
+	return h.deliverTx(tx, source)
+}
+
+// Register must be called before the handler starts serving
+func (h *Handler) Register(method string, fn MethodFunc) {
+	h.methods[method] = fn
+}
+
+// RegisterSend registers a method which submits transactions. Send methods
+// are rate limited separately from everything else.
+func (h *Handler) RegisterSend(method string, fn MethodFunc) {
+	h.methods[method] = fn
+	h.sends[method] = true
+}
+
+// SetMethodPolicy replaces the default policy for proxied methods. Must be
+// called before the handler starts serving.
+func (h *Handler) SetMethodPolicy(policy MethodPolicy) {
+	h.policy = policy
+}
+
+func (h *Handler) allow(req *http.Request, jsr bt.JsRequest) bool {
+	return h.limiter.AllowRequest(req, h.sends[jsr.Method])
+}
+
+func errorResponse(id json.RawMessage, err error) bt.JsResponse {
+	var jsErr *bt.JsError
+	var response bt.JsResponse
+	if errors.As(err, &jsErr) {
+		response = bt.NewJsError(jsErr.Code, jsErr.Message)
+		response.Error.Data = jsErr.Data
+	} else {
+		response = bt.NewJsError(bt.CodeServerError, err.Error())
+	}
+	response.ID = id
+	return response
+}
+
+func writeJSON(res http.ResponseWriter, body interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(res).Encode(body)
+	if err != nil {
+		log.Printf("Encoding error %s", err)
 	}
 }
 
 func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	jsr, err := bt.ParseRequest(req)
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("Error: parsing request body: %v\n", err)
+		log.Printf("Error: reading request body: %v\n", err)
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Request: %+v\n", jsr.Method)
-	if jsr.Method == "eth_sendRawTransaction" ||
-		jsr.Method == "eth_sendTransaction" ||
-		jsr.Method == "eth_sendRawTransaction_reserve" ||
-		jsr.Method == "eth_sendTransaction_reserve" {
+	batch, isBatch, err := bt.ParseBatch(body)
+	if err != nil {
+		log.Printf("Error: parsing request body: %v\n", err)
+		writeJSON(res, bt.NewJsError(bt.CodeParseError, "Parse error"))
+		return
+	}
 
-		tx, err := bt.ExtractTransaction(jsr)
-		if err != nil {
-			log.Printf("Error: extracting transaction %s\n", err)
-			http.Error(res, err.Error(), http.StatusBadRequest)
-			return
+	if !isBatch {
+		jsr := batch[0]
+		log.Printf("Request: %+v\n", jsr.Method)
+		response, proxy := h.Dispatch(req, jsr, len(body))
+		if proxy {
+			log.Printf("Proxy to vanilla: %+v\n", jsr.Method)
+			req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+			h.proxySingle(res, req, jsr)
+		} else if response != nil {
+			writeJSON(res, response)
+		}
+		return
+	}
+
+	if len(batch) == 0 {
+		writeJSON(res, bt.NewJsError(bt.CodeInvalidRequest, "Invalid Request, empty batch"))
+		return
+	}
+
+	responses := h.serveBatch(req, batch)
+	// A batch of notifications gets no response at all
+	if len(responses) > 0 {
+		writeJSON(res, responses)
+	}
+}
+
+// Dispatch answers a single request of the given encoded size unless it
+// should be proxied to the vanilla node, in which case proxy is true. The
+// response is nil when there is nothing to write back.
+func (h *Handler) Dispatch(req *http.Request, jsr bt.JsRequest, size int) (response interface{}, proxy bool) {
+	if !h.allow(req, jsr) {
+		log.Printf("Rate limited: %s\n", jsr.Method)
+		if jsr.IsNotification() {
+			return nil, false
 		}
+		return errorResponse(jsr.ID, ErrRateLimited), false
+	}
 
-		log.Printf("Received: %s\n", tx.Hash().Hex())
-		var response bt.JsResponse
-		result, err := h.processTx(tx)
-		if err != nil {
-			log.Printf("Failed: %s\n%s\n", tx.Hash().Hex(), err)
-			response = bt.NewJsError(-1, err.Error())
+	if _, ok := h.methods[jsr.Method]; !ok && jsr.Validate() == nil {
+		if err := h.policy.Check(jsr.Method, size); err != nil {
+			log.Printf("Blocked: %s\n", jsr.Method)
+			if jsr.IsNotification() {
+				return nil, false
+			}
+			return errorResponse(jsr.ID, err), false
+		}
+		return nil, true
+	}
+
+	callResponse, ok := h.call(req, jsr)
+	if !ok {
+		return nil, false
+	}
+	return callResponse, false
+}
+
+// DispatchBatch answers a batch, proxying what isn't intercepted to the
+// vanilla node. It returns no responses for a batch of notifications.
+func (h *Handler) DispatchBatch(req *http.Request, batch []bt.JsRequest) []json.RawMessage {
+	return h.serveBatch(req, batch)
+}
+
+// Answer an intercepted or invalid request. The response should only be
+// written if ok is true, it is false for notifications.
+func (h *Handler) call(req *http.Request, jsr bt.JsRequest) (bt.JsResponse, bool) {
+	if jsErr := jsr.Validate(); jsErr != nil {
+		return errorResponse(json.RawMessage("null"), jsErr), true
+	}
+
+	method, ok := h.methods[jsr.Method]
+	if !ok {
+		jsErr := &bt.JsError{Code: bt.CodeMethodNotFound, Message: "Method not found"}
+		return errorResponse(jsr.ID, jsErr), !jsr.IsNotification()
+	}
+
+	// Intercepted methods only take params by position
+	if jsr.NamedParams != nil {
+		jsErr := &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid params, expected an array"}
+		return errorResponse(jsr.ID, jsErr), !jsr.IsNotification()
+	}
+
+	var response bt.JsResponse
+	result, err := method(req, jsr)
+	if err != nil {
+		log.Printf("Failed: %s\n%s\n", jsr.Method, err)
+		response = errorResponse(jsr.ID, err)
+	} else {
+		response = bt.NewJsResult(jsr.ID, result)
+	}
+	return response, !jsr.IsNotification()
+}
+
+// Intercepted methods are answered directly and the rest are forwarded to
+// the vanilla node as a single batch. Responses are in request order.
+func (h *Handler) serveBatch(req *http.Request, batch []bt.JsRequest) []json.RawMessage {
+	refused := make([]error, len(batch))
+	proxied := []bt.JsRequest{}
+	positions := map[int]int{}
+	timeout := time.Duration(0)
+	for i, jsr := range batch {
+		if !h.allow(req, jsr) {
+			refused[i] = ErrRateLimited
+			continue
+		}
+		if _, ok := h.methods[jsr.Method]; ok || jsr.Validate() != nil {
+			continue
+		}
+
+		encoded, _ := json.Marshal(jsr)
+		if err := h.policy.Check(jsr.Method, len(encoded)); err != nil {
+			refused[i] = err
+			continue
+		}
+		positions[i] = len(proxied)
+		proxied = append(proxied, jsr)
+
+		// The whole batch gets the shortest timeout of its methods
+		limit := h.policy.Limit(jsr.Method).Timeout()
+		if limit > 0 && (timeout == 0 || limit < timeout) {
+			timeout = limit
+		}
+	}
+
+	upstream := []json.RawMessage{}
+	timedOut := false
+	if len(proxied) > 0 {
+		log.Printf("Proxy batch of %d to vanilla\n", len(proxied))
+		upstream, timedOut = h.proxyBatch(req, proxied, timeout)
+	}
+
+	responses := []json.RawMessage{}
+	for i, jsr := range batch {
+		var response interface{}
+		if refused[i] != nil {
+			if jsr.IsNotification() {
+				continue
+			}
+			response = errorResponse(jsr.ID, refused[i])
+		} else if _, ok := h.methods[jsr.Method]; !ok && jsr.Validate() == nil {
+			if jsr.IsNotification() {
+				continue
+			}
+			var upstreamResponse json.RawMessage
+			if position := positions[i]; position < len(upstream) {
+				upstreamResponse = upstream[position]
+			}
+			ok := upstreamResponse != nil
+			if !ok && timedOut {
+				response = errorResponse(jsr.ID, ErrProxyTimeout)
+			} else if !ok {
+				jsErr := &bt.JsError{Code: bt.CodeInternalError, Message: "No response from vanilla node"}
+				response = errorResponse(jsr.ID, jsErr)
+			} else {
+				response = upstreamResponse
+			}
 		} else {
-			log.Printf("Success: %s\n", tx.Hash().Hex())
-			response = bt.JsResponse{
-				Result: result,
+			callResponse, ok := h.call(req, jsr)
+			if !ok {
+				continue
 			}
+			response = callResponse
 		}
 
-		err = json.NewEncoder(res).Encode(response)
+		encoded, err := json.Marshal(response)
 		if err != nil {
-			log.Fatal("Failed to encode json response")
+			log.Printf("Encoding error %s", err)
+			continue
 		}
-	} else {
-		log.Printf("Proxy to vanilla: %+v\n", jsr.Method)
+		responses = append(responses, encoded)
+	}
+	return responses
+}
+
+// Forward a single request to the vanilla node. Responses are streamed
+// unless the method has a timeout, in which case they are buffered so a
+// JSON-RPC error can be returned instead.
+func (h *Handler) proxySingle(res http.ResponseWriter, req *http.Request, jsr bt.JsRequest) {
+	timeout := h.policy.Limit(jsr.Method).Timeout()
+	if timeout == 0 {
 		h.proxy.ServeHTTP(res, req)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	recorder := newBufferedResponse()
+	h.proxy.ServeHTTP(recorder, req.WithContext(ctx))
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Timed out: %s\n", jsr.Method)
+		if !jsr.IsNotification() {
+			writeJSON(res, errorResponse(jsr.ID, ErrProxyTimeout))
+		}
+		return
+	}
+
+	for key, values := range recorder.header {
+		res.Header()[key] = values
 	}
+	res.WriteHeader(recorder.status)
+	res.Write(recorder.body.Bytes())
 }
 
-func genProcessTx(
+// Forward a batch to the vanilla node and return the responses in request
+// order, nil where there was none. timedOut is true if the timeout, when
+// set, expired first.
+func (h *Handler) proxyBatch(req *http.Request, batch []bt.JsRequest, timeout time.Duration) ([]json.RawMessage, bool) {
+	responses := make([]json.RawMessage, len(batch))
+	body, err := json.Marshal(renumber(batch))
+	if err != nil {
+		log.Printf("Encoding error %s", err)
+		return responses, false
+	}
+
+	ctx := req.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	proxyReq := req.Clone(ctx)
+	proxyReq.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	proxyReq.ContentLength = int64(len(body))
+	recorder := newBufferedResponse()
+	h.proxy.ServeHTTP(recorder, proxyReq)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Batch timed out after %s\n", timeout)
+		return responses, true
+	}
+
+	responses, err = restoreIDs(batch, recorder.body.Bytes())
+	if err != nil {
+		log.Printf("Invalid batch response from vanilla: %s\n", err)
+	}
+	return responses, false
+}
+
+// The vanilla node sees each request's position in the batch as its id,
+// so duplicate ids or ids it formats differently can't be confused.
+// Notifications are left without an id.
+func renumber(batch []bt.JsRequest) []bt.JsRequest {
+	renumbered := make([]bt.JsRequest, len(batch))
+	for i, jsr := range batch {
+		if !jsr.IsNotification() {
+			jsr.ID = json.RawMessage(strconv.Itoa(i))
+		}
+		renumbered[i] = jsr
+	}
+	return renumbered
+}
+
+// Match the responses to a renumbered batch back to its requests and
+// restore their ids. Responses are in request order, nil where the node
+// sent none.
+func restoreIDs(batch []bt.JsRequest, body []byte) ([]json.RawMessage, error) {
+	responses := make([]json.RawMessage, len(batch))
+	var raw []map[string]json.RawMessage
+	err := json.Unmarshal(body, &raw)
+	if err != nil {
+		return responses, err
+	}
+
+	for _, fields := range raw {
+		var position float64
+		if json.Unmarshal(fields["id"], &position) != nil {
+			continue
+		}
+		i := int(position)
+		if float64(i) != position || i < 0 || i >= len(batch) || batch[i].IsNotification() {
+			continue
+		}
+
+		var response interface{}
+		if jsErr, ok := fields["error"]; ok && len(jsErr) > 0 && string(jsErr) != "null" {
+			response = struct {
+				JSONRPC string          `json:"jsonrpc"`
+				Error   json.RawMessage `json:"error"`
+				ID      json.RawMessage `json:"id"`
+			}{"2.0", jsErr, batch[i].ID}
+		} else {
+			result := fields["result"]
+			if len(result) == 0 {
+				result = json.RawMessage("null")
+			}
+			response = struct {
+				JSONRPC string          `json:"jsonrpc"`
+				Result  json.RawMessage `json:"result"`
+				ID      json.RawMessage `json:"id"`
+			}{"2.0", result, batch[i].ID}
+		}
+		responses[i], err = json.Marshal(response)
+		if err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+// bufferedResponse captures a response in memory
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{
+		header: http.Header{},
+		status: http.StatusOK,
+	}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+// The raw transaction can be followed by a rebate
+func (h *Handler) sendTransaction(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+	var param interface{}
+	if len(jsr.Params) == 2 {
+		param = jsr.Params[1]
+		jsr = bt.JsRequest{Params: jsr.Params[:1]}
+	}
+	rebate, err := paramRebate(req, param)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := bt.ExtractTransaction(jsr)
+	if err != nil {
+		log.Printf("Error: extracting transaction %s\n", err)
+		return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: err.Error()}
+	}
+
+	log.Printf("Received: %s\n", tx.Hash().Hex())
+	result, err := h.processTx(tx, requestSource(req), rebate)
+	if err != nil {
+		log.Printf("Failed: %s\n%s\n", tx.Hash().Hex(), err)
+		return nil, err
+	}
+
+	log.Printf("Success: %s\n", tx.Hash().Hex())
+	return result, nil
+}
+
+// Wallets identify themselves with the source query parameter on the RPC URL
+func requestSource(req *http.Request) string {
+	return req.URL.Query().Get("source")
+}
+
+// Validate the transaction and check it against the source's gas policy
+func genCheckTx(
 	gasGetter GasGetter,
-	store st.Store,
-	sender sender.Sender) func(*types.Transaction) (string, error) {
-	return func(tx *types.Transaction) (string, error) {
-		minGas := gasGetter.FastPrice()
-		if tx.GasPrice().Cmp(minGas) == -1 {
-			return "", fmt.Errorf("Gas too low")
+	gasPolicies GasPolicies,
+	validator Validator) func(*types.Transaction, string) error {
+	return func(tx *types.Transaction, source string) error {
+		err := validator.Validate(tx)
+		if err != nil {
+			return err
+		}
+		return gasPolicies.For(source).Check(tx, gasGetter)
+	}
+}
+
+func genProcessTx(
+	checkTx func(*types.Transaction, string) error,
+	tracker *StatusTracker,
+	deliverTx func(*types.Transaction, string) (string, error),
+	limiter *RateLimiter,
+	pool *PendingPool) func(*types.Transaction, string, *Rebate) (string, error) {
+	return func(tx *types.Transaction, source string, rebate *Rebate) (string, error) {
+		if !limiter.AllowSender(tx) {
+			return "", ErrRateLimited
+		}
+
+		err := checkTx(tx, source)
+		if err != nil {
+			if !isNodeError(err) {
+				tracker.Rejected(tx, err)
+			}
+			return "", err
+		}
+
+		if pool == nil {
+			err = tracker.Received(tx, rebate)
+			if err != nil {
+				return "", fmt.Errorf("Error: failed to store transaction %s", err)
+			}
+			return deliverTx(tx, source)
 		}
 
-		entry, err := st.NewLogEntry(tx)
+		// Transactions behind a nonce gap are held in the pool and their
+		// hash returned without delivering them
+		ready, err := pool.Add(tx, source)
 		if err != nil {
-			return "", fmt.Errorf("Error: creating log entry: %s", err)
+			if !isNodeError(err) && err != errAlreadyKnown {
+				tracker.Rejected(tx, err)
+			}
+			return "", err
 		}
 
-		err = store.Save(&entry)
+		err = tracker.Received(tx, rebate)
 		if err != nil {
+			pool.Remove(tx)
 			return "", fmt.Errorf("Error: failed to store transaction %s", err)
 		}
 
-		result, err := sender.Send(tx)
+		result, err := pool.deliver(ready, tx)
 		if err != nil {
-			return "", fmt.Errorf("Error: failed to submit transaction %s", err)
+			pool.Remove(tx)
+			return "", err
+		}
+		return result, nil
+	}
+}
+
+// Send the transaction, its hint or both to the winner depending on the
+// source's hint policy
+func genDeliverTx(
+	txSender sender.Sender,
+	hints HintPolicies,
+	tracker *StatusTracker,
+	async bool) func(*types.Transaction, string) (string, error) {
+	deliver := func(tx *types.Transaction, source string) (string, error) {
+		var err error
+		policy := hints.For(source)
+		result := tx.Hash().Hex()
+		if hintThenSender, ok := txSender.(sender.HintThenSender); ok && policy.Mode == bt.HintModeHintThenFull {
+			result, err = hintThenSender.SendHintThenTransaction(tx, bt.NewHint(tx, policy))
+			if err != nil {
+				return "", fmt.Errorf("Error: failed to submit transaction %w", err)
+			}
+			return result, nil
+		}
+
+		if policy.SendsHint() {
+			hintSender, ok := txSender.(sender.HintSender)
+			if !ok {
+				return "", fmt.Errorf("Error: sender does not support hints")
+			}
+			_, err = hintSender.SendHint(tx, bt.NewHint(tx, policy))
+			if err != nil {
+				return "", fmt.Errorf("Error: failed to submit hint %w", err)
+			}
+		}
+
+		if policy.SendsTransaction() {
+			result, err = txSender.Send(tx)
+			if err != nil {
+				return "", fmt.Errorf("Error: failed to submit transaction %w", err)
+			}
 		}
 
-		return result, err
+		return result, nil
+	}
+
+	// Queued senders report the delivery themselves, possibly before Send
+	// returns, so it's recorded as queued first
+	return func(tx *types.Transaction, source string) (string, error) {
+		if async {
+			tracker.Queued(tx)
+		}
+		result, err := deliver(tx, source)
+		if err != nil && async {
+			tracker.Failed(tx, err)
+			return "", err
+		} else if err != nil {
+			tracker.Rejected(tx, err)
+			return "", err
+		}
+		if !async {
+			tracker.Delivered(tx)
+		}
+		return result, nil
 	}
 }
@@ -0,0 +1,198 @@
+package auction
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nukowsk/bukowskis/internal/sender"
+	"github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func testHandler(t *testing.T) *Handler {
+	local, _ := store.NewLocal()
+	return NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		NewStatusTracker(local, "mock"),
+		sender.MockSender{},
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
+}
+
+// Shared by every test transaction from testTx
+var testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+func testTx(t *testing.T, nonce uint64, gasPrice int64) *types.Transaction {
+	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(1), 21000, big.NewInt(gasPrice), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(999)), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func testRawTx(t *testing.T, gasPrice int64) (string, string) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(gasPrice), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(999)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := bt.HexEncodeTransaction(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw, signed.Hash().Hex()
+}
+
+func serve(h *Handler, body string) string {
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	return strings.TrimSpace(res.Body.String())
+}
+
+func TestHandlerJSONRPC(t *testing.T) {
+	h := testHandler(t)
+	raw, hash := testRawTx(t, 600)
+	lowRaw, _ := testRawTx(t, 1)
+	send := func(id string, raw string) string {
+		idField := ""
+		if id != "" {
+			idField = `,"id":` + id
+		}
+		return `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["` + raw + `"]` + idField + `}`
+	}
+
+	cases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "numeric id",
+			body:     send("7", raw),
+			expected: `{"jsonrpc":"2.0","result":"` + hash + `","id":7}`,
+		},
+		{
+			name:     "string id",
+			body:     send(`"abc"`, raw),
+			expected: `{"jsonrpc":"2.0","result":"` + hash + `","id":"abc"}`,
+		},
+		{
+			name:     "null id",
+			body:     send("null", raw),
+			expected: `{"jsonrpc":"2.0","result":"` + hash + `","id":null}`,
+		},
+		{
+			name:     "notification",
+			body:     send("", raw),
+			expected: ``,
+		},
+		{
+			name:     "proxied",
+			body:     `{"jsonrpc":"2.0","method":"eth_blockNumber","id":3}`,
+			expected: `{"jsonrpc":"2.0","result":null,"id":3}`,
+		},
+		{
+			name:     "parse error",
+			body:     `{"jsonrpc":`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`,
+		},
+		{
+			name:     "invalid request",
+			body:     `{"jsonrpc":"2.0","id":1}`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`,
+		},
+		{
+			name:     "invalid params",
+			body:     `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":[1],"id":4}`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Request, should be a string"},"id":4}`,
+		},
+		{
+			name:     "named params",
+			body:     `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":{"tx":"` + raw + `"},"id":5}`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params, expected an array"},"id":5}`,
+		},
+		{
+			name:     "proxied named params",
+			body:     `[{"jsonrpc":"2.0","method":"eth_blockNumber","params":{},"id":6}]`,
+			expected: `[{"jsonrpc":"2.0","result":null,"id":6}]`,
+		},
+		{
+			name:     "params neither array nor object",
+			body:     `{"jsonrpc":"2.0","method":"eth_blockNumber","params":1,"id":7}`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request, params must be an array or object"},"id":null}`,
+		},
+		{
+			name:     "empty batch",
+			body:     `[]`,
+			expected: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request, empty batch"},"id":null}`,
+		},
+		{
+			name:     "batch of notifications",
+			body:     `[` + send("", raw) + `,{"jsonrpc":"2.0","method":"eth_blockNumber"}]`,
+			expected: ``,
+		},
+		{
+			name: "mixed batch",
+			body: `[` + send("1", raw) + `,{"jsonrpc":"2.0","method":"eth_blockNumber","id":"b"},` +
+				send("", raw) + `,1]`,
+			expected: `[{"jsonrpc":"2.0","result":"` + hash + `","id":1},` +
+				`{"jsonrpc":"2.0","result":null,"id":"b"},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := serve(h, c.body)
+			if got != c.expected {
+				t.Errorf("\nexpected: %s\ngot:      %s", c.expected, got)
+			}
+		})
+	}
+
+	var response bt.JsResponse
+	err := json.Unmarshal([]byte(serve(h, send("9", lowRaw))), &response)
+	if err != nil || response.Error == nil || response.Error.Code != bt.CodeTransactionRejected {
+		t.Errorf("expected rejection, got %+v", response)
+	}
+}
+
+func TestHandlerBatchDuplicateIDs(t *testing.T) {
+	local, _ := store.NewLocal()
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		NewStatusTracker(local, "mock"),
+		sender.MockSender{},
+		&countingProxy{calls: map[string]int{}},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
+	raw, hash := testRawTx(t, 600)
+
+	body := `[{"jsonrpc":"2.0","method":"eth_chainId","id":1},` +
+		`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["` + raw + `"],"id":1},` +
+		`{"jsonrpc":"2.0","method":"eth_chainId","id":1}]`
+	expected := `[{"jsonrpc":"2.0","result":1,"id":1},` +
+		`{"jsonrpc":"2.0","result":"` + hash + `","id":1},` +
+		`{"jsonrpc":"2.0","result":2,"id":1}]`
+	if got := serve(h, body); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
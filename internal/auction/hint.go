@@ -0,0 +1,51 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Used for sources without their own entry
+const defaultSource = "default"
+
+// HintPolicies maps a transaction source to the hint policy applied to its
+// transactions
+type HintPolicies map[string]bt.HintPolicy
+
+// Load policies from a JSON file of the form
+// {"default": {"mode": "full"}, "wallet": {"mode": "hint", "to": true}}
+func LoadHintPolicies(path string) (HintPolicies, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies HintPolicies
+	err = json.Unmarshal(contents, &policies)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode hint policies: %s", err)
+	}
+
+	for source, policy := range policies {
+		switch policy.Mode {
+		case "", bt.HintModeFull, bt.HintModeHint, bt.HintModeHintThenFull:
+		default:
+			return nil, fmt.Errorf("Unknown hint mode %q for %s", policy.Mode, source)
+		}
+	}
+
+	return policies, nil
+}
+
+func (h HintPolicies) For(source string) bt.HintPolicy {
+	if policy, ok := h[source]; ok {
+		return policy
+	}
+	if policy, ok := h[defaultSource]; ok {
+		return policy
+	}
+	return bt.HintPolicy{Mode: bt.HintModeFull}
+}
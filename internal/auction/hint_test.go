@@ -0,0 +1,61 @@
+package auction
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func TestLoadHintPolicies(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		valid    bool
+	}{
+		{"modes", `{"default": {"mode": "full"}, "wallet": {"mode": "hint", "to": true}, "dex": {"mode": "hint_then_full"}}`, true},
+		{"empty mode", `{"wallet": {"to": true}}`, true},
+		{"unknown mode", `{"wallet": {"mode": "partial"}}`, false},
+		{"invalid json", `{"wallet": `, false},
+	}
+
+	dir := t.TempDir()
+	for _, c := range cases {
+		path := filepath.Join(dir, c.name+".json")
+		if err := ioutil.WriteFile(path, []byte(c.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadHintPolicies(path)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %t, got %v", c.name, c.valid, err)
+		}
+	}
+
+	if _, err := LoadHintPolicies(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
+
+func TestHintPoliciesFor(t *testing.T) {
+	wallet := bt.HintPolicy{Mode: bt.HintModeHint, To: true}
+	fallback := bt.HintPolicy{Mode: bt.HintModeHintThenFull}
+
+	cases := []struct {
+		name     string
+		policies HintPolicies
+		source   string
+		expected bt.HintPolicy
+	}{
+		{"source", HintPolicies{"wallet": wallet, defaultSource: fallback}, "wallet", wallet},
+		{"default", HintPolicies{"wallet": wallet, defaultSource: fallback}, "other", fallback},
+		{"no default", HintPolicies{"wallet": wallet}, "other", bt.HintPolicy{Mode: bt.HintModeFull}},
+		{"no policies", HintPolicies{}, "", bt.HintPolicy{Mode: bt.HintModeFull}},
+	}
+
+	for _, c := range cases {
+		if policy := c.policies.For(c.source); policy != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, policy)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+var ErrProxyTimeout = &bt.JsError{Code: bt.CodeServerError, Message: "Request timed out"}
+
+// MethodLimit bounds a proxied method. Zero values are not enforced.
+type MethodLimit struct {
+	MaxRequestSize int   `json:"maxRequestSize"`
+	TimeoutMs      int64 `json:"timeoutMs"`
+}
+
+func (l MethodLimit) Timeout() time.Duration {
+	return time.Duration(l.TimeoutMs) * time.Millisecond
+}
+
+// MethodPolicy decides which methods are proxied to the vanilla node.
+// Patterns are method names or namespace prefixes ending in "*" such as
+// "debug_*". A method must match Allow, when it is set, and must not match
+// Deny. Limits are looked up by exact method first and then by the
+// longest matching pattern. Intercepted methods are not affected.
+type MethodPolicy struct {
+	Allow  []string               `json:"allow"`
+	Deny   []string               `json:"deny"`
+	Limits map[string]MethodLimit `json:"limits"`
+}
+
+// Namespaces which expose node administration or accounts
+func DefaultMethodPolicy() MethodPolicy {
+	return MethodPolicy{
+		Deny: []string{"admin_*", "debug_*", "personal_*", "miner_*"},
+	}
+}
+
+// A configured policy replaces the default one entirely
+func LoadMethodPolicy(path string) (MethodPolicy, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return MethodPolicy{}, err
+	}
+
+	var policy MethodPolicy
+	err = json.Unmarshal(contents, &policy)
+	if err != nil {
+		return MethodPolicy{}, fmt.Errorf("Failed to decode method policy: %s", err)
+	}
+	return policy, nil
+}
+
+func matchMethod(pattern string, method string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == method
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p MethodPolicy) Allowed(method string) bool {
+	if len(p.Allow) > 0 && !matchAny(p.Allow, method) {
+		return false
+	}
+	return !matchAny(p.Deny, method)
+}
+
+func (p MethodPolicy) Limit(method string) MethodLimit {
+	if limit, ok := p.Limits[method]; ok {
+		return limit
+	}
+
+	best := ""
+	for pattern := range p.Limits {
+		if matchMethod(pattern, method) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	return p.Limits[best]
+}
+
+// Check returns the JSON-RPC error for a request which may not be proxied
+func (p MethodPolicy) Check(method string, size int) error {
+	if !p.Allowed(method) {
+		return &bt.JsError{Code: bt.CodeMethodNotFound, Message: fmt.Sprintf("Method %s is not allowed", method)}
+	}
+
+	limit := p.Limit(method)
+	if limit.MaxRequestSize > 0 && size > limit.MaxRequestSize {
+		return &bt.JsError{Code: bt.CodeInvalidRequest, Message: fmt.Sprintf("Request too large, %s is limited to %d bytes", method, limit.MaxRequestSize)}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package auction
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type slowProxy struct {
+	delay time.Duration
+}
+
+func (s slowProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	select {
+	case <-time.After(s.delay):
+		MockProxy{}.ServeHTTP(res, req)
+	case <-req.Context().Done():
+	}
+}
+
+func TestMethodPolicy(t *testing.T) {
+	h := testHandler(t)
+	h.SetMethodPolicy(MethodPolicy{
+		Allow: []string{"eth_*", "net_version"},
+		Deny:  []string{"eth_sign"},
+		Limits: map[string]MethodLimit{
+			"eth_*":    {MaxRequestSize: 1000},
+			"eth_call": {MaxRequestSize: 80},
+		},
+	})
+
+	cases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "allowed",
+			body:     `{"jsonrpc":"2.0","method":"net_version","id":1}`,
+			expected: `"result":null`,
+		},
+		{
+			name:     "not allowed",
+			body:     `{"jsonrpc":"2.0","method":"admin_peers","id":1}`,
+			expected: `"code":-32601,"message":"Method admin_peers is not allowed"`,
+		},
+		{
+			name:     "denied",
+			body:     `{"jsonrpc":"2.0","method":"eth_sign","id":1}`,
+			expected: `"code":-32601,"message":"Method eth_sign is not allowed"`,
+		},
+		{
+			name:     "too large",
+			body:     `{"jsonrpc":"2.0","method":"eth_call","params":["` + strings.Repeat("0", 100) + `"],"id":1}`,
+			expected: `"code":-32600`,
+		},
+		{
+			name:     "intercepted",
+			body:     `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":[1],"id":1}`,
+			expected: `"code":-32602`,
+		},
+		{
+			name:     "batch",
+			body:     `[{"jsonrpc":"2.0","method":"eth_blockNumber","id":1},{"jsonrpc":"2.0","method":"debug_traceTransaction","id":2}]`,
+			expected: `[{"jsonrpc":"2.0","result":null,"id":1},{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method debug_traceTransaction is not allowed"},"id":2}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := serve(h, c.body)
+			if !strings.Contains(got, c.expected) {
+				t.Errorf("\nexpected: %s\ngot:      %s", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestMethodTimeout(t *testing.T) {
+	h := testHandler(t)
+	h.proxy = slowProxy{delay: time.Second}
+	h.SetMethodPolicy(MethodPolicy{
+		Limits: map[string]MethodLimit{"eth_getLogs": {TimeoutMs: 10}},
+	})
+
+	timedOut := `"code":-32000,"message":"Request timed out"`
+	if got := serve(h, `{"jsonrpc":"2.0","method":"eth_getLogs","id":1}`); !strings.Contains(got, timedOut) {
+		t.Errorf("expected a timeout, got %s", got)
+	}
+
+	batch := `[{"jsonrpc":"2.0","method":"eth_getLogs","id":1},{"jsonrpc":"2.0","method":"eth_chainId","id":2}]`
+	if got := serve(h, batch); strings.Count(got, timedOut) != 2 {
+		t.Errorf("expected the batch to time out, got %s", got)
+	}
+}
@@ -0,0 +1,419 @@
+package auction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+const (
+	// Pending transactions are evicted this many blocks after being added
+	pendingMaxAge = 50
+
+	// Most transactions held for a single sender
+	pendingMaxPerSender = 64
+
+	// Replacements must raise the fee cap and tip by this percentage
+	pendingPriceBump = 10
+
+	// Nonces are refreshed on every block with at most this many requests
+	// to the node at once, all of which must finish within the timeout
+	pendingNonceWorkers = 8
+	pendingNonceTimeout = 2 * time.Second
+)
+
+type pendingTx struct {
+	tx        *types.Transaction
+	from      common.Address
+	source    string
+	added     uint64
+	delivered bool
+	// The transaction this one replaced, put back if it can't be delivered
+	replaced *pendingTx
+}
+
+// Transactions from one sender. nonce is the sender's nonce on chain so
+// transactions from it up to the first gap can be delivered.
+type pendingAccount struct {
+	nonce uint64
+	txs   map[uint64]*pendingTx
+}
+
+// PendingPool holds accepted transactions until they are included. Those
+// with a nonce beyond a gap are held back until their predecessors arrive,
+// either through the auction or on chain.
+type PendingPool struct {
+	mx        sync.Mutex
+	blocks    *BlockWatcher
+	state     StateReader
+	client    *rpc.Client
+	tracker   *StatusTracker
+	accounts  map[common.Address]*pendingAccount
+	byHash    map[common.Hash]*pendingTx
+	deliverTx func(*types.Transaction, string) (string, error)
+}
+
+// client answers lookups for transactions which aren't in the pool and
+// the tracker records expired transactions as dropped
+func NewPendingPool(blocks *BlockWatcher, state StateReader, client *rpc.Client, tracker *StatusTracker) *PendingPool {
+	p := &PendingPool{
+		blocks:   blocks,
+		state:    state,
+		client:   client,
+		tracker:  tracker,
+		accounts: map[common.Address]*pendingAccount{},
+		byHash:   map[common.Hash]*pendingTx{},
+	}
+	blocks.Subscribe(p.onBlock)
+	return p
+}
+
+// Set by the handler, promoted transactions are delivered with it
+func (p *PendingPool) setDeliver(deliverTx func(*types.Transaction, string) (string, error)) {
+	p.deliverTx = deliverTx
+}
+
+// Returned for a transaction the pool already holds, which isn't a
+// rejection of it
+var errAlreadyKnown = rejection("already known", nil)
+
+func bumped(price *big.Int, previous *big.Int) bool {
+	min := new(big.Int).Mul(previous, big.NewInt(100+pendingPriceBump))
+	return new(big.Int).Mul(price, big.NewInt(100)).Cmp(min) >= 0
+}
+
+// Add returns the transactions which can now be delivered, in nonce order.
+// They are marked as delivered.
+func (p *PendingPool) Add(tx *types.Transaction, source string) ([]*pendingTx, error) {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, rejection("invalid sender", nil)
+	}
+
+	p.mx.Lock()
+	account, ok := p.accounts[from]
+	p.mx.Unlock()
+	if !ok {
+		nonce, err := p.state.NonceAt(context.Background(), from, nil)
+		if err != nil {
+			return nil, &nodeError{fmt.Errorf("Error: failed to get nonce %s", err)}
+		}
+		account = &pendingAccount{nonce: nonce, txs: map[uint64]*pendingTx{}}
+	}
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if existing, ok := p.accounts[from]; ok {
+		account = existing
+	}
+
+	if tx.Nonce() < account.nonce {
+		return nil, rejection("nonce too low", nil)
+	}
+
+	entry := &pendingTx{
+		tx:     tx,
+		from:   from,
+		source: source,
+		added:  p.blocks.Head(),
+	}
+
+	if previous, ok := account.txs[tx.Nonce()]; ok {
+		if previous.tx.Hash() == tx.Hash() {
+			return nil, errAlreadyKnown
+		}
+		if !bumped(tx.GasFeeCap(), previous.tx.GasFeeCap()) || !bumped(tx.GasTipCap(), previous.tx.GasTipCap()) {
+			return nil, rejection("replacement transaction underpriced", nil)
+		}
+		delete(p.byHash, previous.tx.Hash())
+		previous.replaced = nil
+		entry.replaced = previous
+	} else if len(account.txs) >= pendingMaxPerSender {
+		return nil, rejection("too many pending transactions", nil)
+	}
+
+	account.txs[tx.Nonce()] = entry
+	p.accounts[from] = account
+	p.byHash[tx.Hash()] = entry
+	return account.promote(), nil
+}
+
+// Mark and return the undelivered transactions before the first gap
+func (a *pendingAccount) promote() []*pendingTx {
+	ready := []*pendingTx{}
+	for nonce := a.nonce; ; nonce++ {
+		entry, ok := a.txs[nonce]
+		if !ok {
+			return ready
+		}
+		if !entry.delivered {
+			entry.delivered = true
+			ready = append(ready, entry)
+		}
+	}
+}
+
+// Remove a transaction which couldn't be delivered, putting back the one
+// it replaced
+func (p *PendingPool) Remove(tx *types.Transaction) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	entry, ok := p.byHash[tx.Hash()]
+	if !ok {
+		return
+	}
+	delete(p.byHash, tx.Hash())
+	account, ok := p.accounts[entry.from]
+	if !ok || account.txs[tx.Nonce()] != entry {
+		return
+	}
+	if entry.replaced == nil {
+		delete(account.txs, tx.Nonce())
+		return
+	}
+	account.txs[tx.Nonce()] = entry.replaced
+	p.byHash[entry.replaced.tx.Hash()] = entry.replaced
+}
+
+// Redeliver sends a transaction the pool has already accepted to the
+// winner again. One still held behind a nonce gap isn't sent until the
+// gap is filled.
+func (p *PendingPool) Redeliver(tx *types.Transaction, source string) (string, error) {
+	p.mx.Lock()
+	entry, ok := p.byHash[tx.Hash()]
+	held := ok && !entry.delivered
+	p.mx.Unlock()
+	if held {
+		return tx.Hash().Hex(), nil
+	}
+	return p.deliverTx(tx, source)
+}
+
+func (p *PendingPool) Get(hash common.Hash) (*types.Transaction, common.Address, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	entry, ok := p.byHash[hash]
+	if !ok {
+		return nil, common.Address{}, false
+	}
+	return entry.tx, entry.from, true
+}
+
+// The sender's next nonce counting the pool's transactions up to the first
+// gap, given the node's pending count
+func (p *PendingPool) NextNonce(from common.Address, nonce uint64) uint64 {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	account, ok := p.accounts[from]
+	if !ok {
+		return nonce
+	}
+	if account.nonce > nonce {
+		nonce = account.nonce
+	}
+	for {
+		if _, ok := account.txs[nonce]; !ok {
+			return nonce
+		}
+		nonce++
+	}
+}
+
+// Evict included and expired transactions and deliver any which are no
+// longer behind a gap
+func (p *PendingPool) onBlock(head uint64) {
+	p.mx.Lock()
+	senders := []common.Address{}
+	for from := range p.accounts {
+		senders = append(senders, from)
+	}
+	p.mx.Unlock()
+
+	nonces, errs := p.nonces(senders)
+	ready := []*pendingTx{}
+	expired := []*types.Transaction{}
+	for i, from := range senders {
+		if errs[i] != nil {
+			log.Printf("Failed to get nonce for %s: %s\n", from.Hex(), errs[i])
+			continue
+		}
+
+		p.mx.Lock()
+		account, ok := p.accounts[from]
+		if !ok {
+			p.mx.Unlock()
+			continue
+		}
+		account.nonce = nonces[i]
+		for n, entry := range account.txs {
+			if n < account.nonce {
+				delete(account.txs, n)
+				delete(p.byHash, entry.tx.Hash())
+			} else if entry.added+pendingMaxAge < head {
+				delete(account.txs, n)
+				delete(p.byHash, entry.tx.Hash())
+				expired = append(expired, entry.tx)
+			}
+		}
+		if len(account.txs) == 0 {
+			delete(p.accounts, from)
+		} else {
+			ready = append(ready, account.promote()...)
+		}
+		p.mx.Unlock()
+	}
+
+	// Included transactions are recorded by the tracker itself
+	for _, tx := range expired {
+		log.Printf("Expired %s\n", tx.Hash().Hex())
+		p.tracker.Dropped(tx, "expired from the pending pool")
+	}
+
+	for _, entry := range ready {
+		log.Printf("Promoting %s\n", entry.tx.Hash().Hex())
+	}
+	p.deliver(ready, nil)
+}
+
+// Look up the senders' nonces, at most pendingNonceWorkers at a time
+func (p *PendingPool) nonces(senders []common.Address) ([]uint64, []error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pendingNonceTimeout)
+	defer cancel()
+
+	nonces := make([]uint64, len(senders))
+	errs := make([]error, len(senders))
+	workers := make(chan struct{}, pendingNonceWorkers)
+	var wg sync.WaitGroup
+	for i, from := range senders {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, from common.Address) {
+			defer wg.Done()
+			nonces[i], errs[i] = p.state.NonceAt(ctx, from, nil)
+			<-workers
+		}(i, from)
+	}
+	wg.Wait()
+	return nonces, errs
+}
+
+// Deliver promoted transactions in nonce order. When one fails, it and the
+// sender's later transactions are unmarked so they are promoted again on
+// the next block. Returns the result of delivering tx, or its hash if it
+// is still waiting.
+func (p *PendingPool) deliver(ready []*pendingTx, tx *types.Transaction) (string, error) {
+	var result string
+	var txErr error
+	if tx != nil {
+		result = tx.Hash().Hex()
+	}
+
+	failed := map[common.Address]bool{}
+	for _, entry := range ready {
+		if failed[entry.from] {
+			p.unmark(entry)
+			continue
+		}
+
+		delivered, err := p.deliverTx(entry.tx, entry.source)
+		if err != nil {
+			log.Printf("Failed to deliver %s: %s\n", entry.tx.Hash().Hex(), err)
+			failed[entry.from] = true
+			p.unmark(entry)
+		}
+		if tx != nil && entry.tx.Hash() == tx.Hash() {
+			result, txErr = delivered, err
+		}
+	}
+	return result, txErr
+}
+
+func (p *PendingPool) unmark(entry *pendingTx) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	entry.delivered = false
+}
+
+// The node's JSON for a pending transaction, which also has the sender and
+// null block fields
+func pendingTransactionJSON(tx *types.Transaction, from common.Address) (map[string]interface{}, error) {
+	encoded, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(encoded, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fields["from"] = from
+	fields["blockHash"] = nil
+	fields["blockNumber"] = nil
+	fields["transactionIndex"] = nil
+	if _, ok := fields["gasPrice"]; !ok {
+		fields["gasPrice"] = fields["maxFeePerGas"]
+	}
+	return fields, nil
+}
+
+// eth_getTransactionByHash answers from the pool and asks the vanilla node
+// for everything else
+func (p *PendingPool) GetTransactionMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		hash, err := hashParam(jsr)
+		if err != nil {
+			return nil, err
+		}
+
+		if tx, from, ok := p.Get(common.HexToHash(hash)); ok {
+			return pendingTransactionJSON(tx, from)
+		}
+
+		var result json.RawMessage
+		err = p.client.CallContext(req.Context(), &result, "eth_getTransactionByHash", hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 {
+			return nil, nil
+		}
+		return result, nil
+	}
+}
+
+// eth_getTransactionCount for the pending tag adds the pool's transactions
+// to the node's count, which hasn't seen them. Other tags are only asked
+// of the node.
+func (p *PendingPool) TransactionCountMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		if len(jsr.Params) != 2 {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected address and block"}
+		}
+		address, ok := jsr.Params[0].(string)
+		if !ok || !common.IsHexAddress(address) {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, address should be a hex string"}
+		}
+
+		var count hexutil.Uint64
+		err := p.client.CallContext(req.Context(), &count, "eth_getTransactionCount", jsr.Params...)
+		if err != nil {
+			return nil, err
+		}
+		if tag, _ := jsr.Params[1].(string); tag != "pending" {
+			return count, nil
+		}
+		return hexutil.Uint64(p.NextNonce(common.HexToAddress(address), uint64(count))), nil
+	}
+}
@@ -0,0 +1,238 @@
+package auction
+
+import (
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func TestPendingPool(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	node := stubNode(t, map[string]interface{}{
+		"eth_getTransactionByHash": nil,
+		"eth_getTransactionCount":  "0x5",
+	})
+	defer node.Close()
+	client, err := rpc.DialHTTP(node.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &stubState{nonce: 5, balance: big.NewInt(0)}
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(local, "bidder")
+	pool := NewPendingPool(blocks, state, client, tracker)
+	promoted := []uint64{}
+	pool.setDeliver(func(tx *types.Transaction, source string) (string, error) {
+		promoted = append(promoted, tx.Nonce())
+		return tx.Hash().Hex(), nil
+	})
+
+	nonces := func(entries []*pendingTx) []uint64 {
+		n := []uint64{}
+		for _, entry := range entries {
+			n = append(n, entry.tx.Nonce())
+		}
+		return n
+	}
+
+	// Nonce 7 waits for 6
+	if ready, err := pool.Add(testTx(t, 5, 100), ""); err != nil || len(ready) != 1 {
+		t.Fatalf("expected the next nonce to be ready, got %v %v", nonces(ready), err)
+	}
+	held := testTx(t, 7, 100)
+	if ready, err := pool.Add(held, ""); err != nil || len(ready) != 0 {
+		t.Fatalf("expected the future nonce to be held, got %v %v", nonces(ready), err)
+	}
+
+	// The pending count stops at the gap
+	count := pool.TransactionCountMethod()
+	from := crypto.PubkeyToAddress(testKey.PublicKey).Hex()
+	for tag, expected := range map[string]hexutil.Uint64{"pending": 6, "latest": 5} {
+		result, err := count(httptest.NewRequest("POST", "/", nil), bt.JsRequest{Params: []interface{}{from, tag}})
+		if err != nil || result != expected {
+			t.Errorf("expected %s count %d, got %v %v", tag, expected, result, err)
+		}
+	}
+	ready, err := pool.Add(testTx(t, 6, 100), "")
+	if err != nil || len(ready) != 2 || ready[0].tx.Nonce() != 6 || ready[1].tx.Nonce() != 7 {
+		t.Fatalf("expected the gap to release both, got %v %v", nonces(ready), err)
+	}
+
+	if _, err := pool.Add(testTx(t, 6, 105), ""); err == nil {
+		t.Error("expected an underpriced replacement to be rejected")
+	}
+	replacement := testTx(t, 6, 110)
+	if ready, err := pool.Add(replacement, ""); err != nil || len(ready) != 1 {
+		t.Errorf("expected the replacement to be delivered, got %v %v", nonces(ready), err)
+	}
+
+	// Future nonces beyond a gap filled on chain are promoted
+	if _, err := pool.Add(testTx(t, 9, 100), ""); err != nil {
+		t.Fatal(err)
+	}
+	state.nonce = 9
+	chain.head = 101
+	blocks.poll()
+	if len(promoted) != 1 || promoted[0] != 9 {
+		t.Errorf("expected nonce 9 to be promoted, got %v", promoted)
+	}
+	if _, _, ok := pool.Get(held.Hash()); ok {
+		t.Error("expected the included transaction to be evicted")
+	}
+
+	method := pool.GetTransactionMethod()
+	request := httptest.NewRequest("POST", "/", nil)
+	lookup := func(hash common.Hash) interface{} {
+		result, err := method(request, bt.JsRequest{Params: []interface{}{hash.Hex()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	pending := testTx(t, 9, 100)
+	fields, ok := lookup(pending.Hash()).(map[string]interface{})
+	if !ok || fields["blockHash"] != nil || fields["hash"] != pending.Hash().Hex() {
+		t.Errorf("expected the pooled transaction, got %v", fields)
+	}
+	if result := lookup(replacement.Hash()); result != nil {
+		t.Errorf("expected the node's null answer, got %s", result)
+	}
+
+	// Expired transactions are evicted and reported as dropped
+	if err := tracker.Received(pending, nil); err != nil {
+		t.Fatal(err)
+	}
+	chain.head = 101 + pendingMaxAge + 1
+	blocks.poll()
+	if _, _, ok := pool.Get(pending.Hash()); ok {
+		t.Error("expected the expired transaction to be evicted")
+	}
+	if status, _ := tracker.Status(pending.Hash().Hex()); status == nil || status.Status != st.StatusDropped {
+		t.Errorf("expected the expired transaction to be dropped, got %+v", status)
+	}
+}
+
+func TestPendingPoolDeliveryFailure(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	state := &stubState{nonce: 5, balance: big.NewInt(0)}
+	local, _ := st.NewLocal()
+	pool := NewPendingPool(blocks, state, nil, NewStatusTracker(local, "bidder"))
+	failing := uint64(6)
+	delivered := []uint64{}
+	pool.setDeliver(func(tx *types.Transaction, source string) (string, error) {
+		if tx.Nonce() == failing {
+			return "", errors.New("busy")
+		}
+		delivered = append(delivered, tx.Nonce())
+		return tx.Hash().Hex(), nil
+	})
+
+	for _, nonce := range []uint64{6, 7} {
+		if ready, err := pool.Add(testTx(t, nonce, 100), ""); err != nil || len(ready) != 0 {
+			t.Fatalf("expected nonce %d to be held, got %d %v", nonce, len(ready), err)
+		}
+	}
+	first := testTx(t, 5, 100)
+	ready, err := pool.Add(first, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := pool.deliver(ready, first); err != nil || result != first.Hash().Hex() {
+		t.Fatalf("expected the new transaction to be delivered, got %s %v", result, err)
+	}
+	if len(delivered) != 1 || delivered[0] != 5 {
+		t.Errorf("expected delivery to stop at the failure, got %v", delivered)
+	}
+
+	// The failed transaction and those after it are retried on the next block
+	failing = 0
+	chain.head = 101
+	blocks.poll()
+	if len(delivered) != 3 || delivered[1] != 6 || delivered[2] != 7 {
+		t.Errorf("expected the rest to be retried in order, got %v", delivered)
+	}
+}
+
+// recordingSender records what it sent and fails while fail is set
+type recordingSender struct {
+	sent []uint64
+	fail bool
+}
+
+func (r *recordingSender) Send(tx *types.Transaction) (string, error) {
+	if r.fail {
+		return "", errors.New("busy")
+	}
+	r.sent = append(r.sent, tx.Nonce())
+	return tx.Hash().Hex(), nil
+}
+
+func TestPendingPoolHandler(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	state := &stubState{nonce: 5, balance: big.NewInt(0)}
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(local, "bidder")
+	pool := NewPendingPool(blocks, state, nil, tracker)
+	txSender := &recordingSender{}
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(100)},
+		tracker,
+		txSender,
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		pool)
+
+	// Resubmitting a held transaction leaves it held, and it isn't sent
+	// again until the gap is filled
+	held := testTx(t, 6, 100)
+	for i := 0; i < 2; i++ {
+		h.ProcessTransaction(held, "", nil)
+	}
+	if status, _ := tracker.Status(held.Hash().Hex()); status == nil || status.Status != st.StatusReceived {
+		t.Errorf("expected the resubmitted transaction to stay received, got %+v", status)
+	}
+	if _, err := h.DeliverTransaction(held, ""); err != nil || len(txSender.sent) != 0 {
+		t.Errorf("expected the held transaction not to be re-delivered, got %v %v", txSender.sent, err)
+	}
+
+	// A replacement which can't be delivered leaves the original in place
+	first := testTx(t, 5, 100)
+	if _, err := h.ProcessTransaction(first, "", nil); err != nil || len(txSender.sent) != 2 {
+		t.Fatalf("expected the gap to release the held transaction, got %v %v", txSender.sent, err)
+	}
+	txSender.fail = true
+	if _, err := h.ProcessTransaction(testTx(t, 6, 110), "", nil); err == nil {
+		t.Fatal("expected the replacement to fail")
+	}
+	if tx, _, ok := pool.Get(held.Hash()); !ok || tx.Hash() != held.Hash() {
+		t.Error("expected the original to be put back")
+	}
+	txSender.fail = false
+	if _, err := h.DeliverTransaction(held, ""); err != nil || len(txSender.sent) != 3 {
+		t.Errorf("expected the original to be re-delivered, got %v %v", txSender.sent, err)
+	}
+}
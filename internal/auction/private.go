@@ -0,0 +1,302 @@
+package auction
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+const (
+	PrivatePending   = "pending"
+	PrivateIncluded  = "included"
+	PrivateExpired   = "expired"
+	PrivateCancelled = "cancelled"
+)
+
+// Private transactions without a maxBlockNumber are kept this many blocks
+const privateDefaultBlocks = 25
+
+type ReceiptGetter interface {
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+}
+
+// Receipts are looked up on every block, this bounds how long that holds
+// up the other subscribers
+const (
+	receiptWorkers = 8
+	receiptTimeout = 2 * time.Second
+)
+
+// Look up the transactions' receipts, at most receiptWorkers at a time
+func fetchReceipts(receipts ReceiptGetter, hashes []common.Hash) ([]*types.Receipt, []error) {
+	ctx, cancel := context.WithTimeout(context.Background(), receiptTimeout)
+	defer cancel()
+
+	results := make([]*types.Receipt, len(hashes))
+	errs := make([]error, len(hashes))
+	workers := make(chan struct{}, receiptWorkers)
+	var wg sync.WaitGroup
+	for i, hash := range hashes {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, hash common.Hash) {
+			defer wg.Done()
+			results[i], errs[i] = receipts.TransactionReceipt(ctx, hash)
+			<-workers
+		}(i, hash)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// Fast is accepted for compatibility with Flashbots Protect, every private
+// transaction is delivered to each block's winner. Rebate overrides the one
+// on the RPC URL.
+type PrivatePreferences struct {
+	Fast   bool    `json:"fast"`
+	Rebate *Rebate `json:"rebate"`
+}
+
+type SendPrivateArgs struct {
+	Tx             string             `json:"tx"`
+	MaxBlockNumber *hexutil.Uint64    `json:"maxBlockNumber"`
+	Preferences    PrivatePreferences `json:"preferences"`
+}
+
+// Signature is the transaction sender's personal_sign signature of the
+// transaction hash as a hex string, only the sender can cancel
+type CancelPrivateArgs struct {
+	TxHash    common.Hash   `json:"txHash"`
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+type privateTx struct {
+	tx       *types.Transaction
+	source   string
+	maxBlock uint64
+	status   string
+}
+
+// PrivateBook keeps private transactions out of the public mempool and
+// delivers them to the winner of every block until they are included, they
+// expire at their max block or they are cancelled
+type PrivateBook struct {
+	mx        sync.Mutex
+	txs       map[common.Hash]*privateTx
+	blocks    *BlockWatcher
+	receipts  ReceiptGetter
+	processTx func(*types.Transaction, string, *Rebate) (string, error)
+	deliverTx func(*types.Transaction, string) (string, error)
+}
+
+// processTx admits and delivers a new transaction, deliverTx re-delivers
+// it for later blocks
+func NewPrivateBook(
+	blocks *BlockWatcher,
+	receipts ReceiptGetter,
+	processTx func(*types.Transaction, string, *Rebate) (string, error),
+	deliverTx func(*types.Transaction, string) (string, error)) *PrivateBook {
+	p := &PrivateBook{
+		txs:       map[common.Hash]*privateTx{},
+		blocks:    blocks,
+		receipts:  receipts,
+		processTx: processTx,
+		deliverTx: deliverTx,
+	}
+	blocks.Subscribe(p.onBlock)
+	return p
+}
+
+func (p *PrivateBook) Send(tx *types.Transaction, source string, maxBlock uint64, rebate *Rebate) (string, error) {
+	head := p.blocks.Head()
+	if maxBlock == 0 {
+		maxBlock = head + privateDefaultBlocks
+	}
+	if head == 0 || maxBlock <= head {
+		return "", fmt.Errorf("Max block %d has already been mined", maxBlock)
+	}
+
+	p.mx.Lock()
+	if _, ok := p.txs[tx.Hash()]; ok {
+		p.mx.Unlock()
+		return "", fmt.Errorf("Transaction %s already sent", tx.Hash().Hex())
+	}
+	p.txs[tx.Hash()] = &privateTx{
+		tx:       tx,
+		source:   source,
+		maxBlock: maxBlock,
+		status:   PrivatePending,
+	}
+	p.mx.Unlock()
+
+	result, err := p.processTx(tx, source, rebate)
+	if err != nil {
+		p.mx.Lock()
+		delete(p.txs, tx.Hash())
+		p.mx.Unlock()
+		return "", err
+	}
+	return result, nil
+}
+
+// Cancel stops further deliveries if the signature is the sender's.
+// Returns false if the transaction is unknown or no longer pending.
+func (p *PrivateBook) Cancel(hash common.Hash, signature []byte) (bool, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	ptx, ok := p.txs[hash]
+	if !ok || ptx.status != PrivatePending {
+		return false, nil
+	}
+	if err := checkCancelSignature(ptx.tx, signature); err != nil {
+		return false, err
+	}
+	ptx.status = PrivateCancelled
+	return true, nil
+}
+
+func checkCancelSignature(tx *types.Transaction, signature []byte) error {
+	invalid := &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid signature, should be signed by the transaction's sender"}
+	if len(signature) != crypto.SignatureLength {
+		return invalid
+	}
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(tx.Hash().Hex())), sig)
+	if err != nil {
+		return invalid
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil || crypto.PubkeyToAddress(*pub) != from {
+		return invalid
+	}
+	return nil
+}
+
+func (p *PrivateBook) onBlock(head uint64) {
+	pending := []*privateTx{}
+	p.mx.Lock()
+	for hash, ptx := range p.txs {
+		if ptx.status == PrivatePending {
+			pending = append(pending, ptx)
+		} else if ptx.maxBlock+reservationRetention < head {
+			delete(p.txs, hash)
+		}
+	}
+	p.mx.Unlock()
+
+	hashes := make([]common.Hash, len(pending))
+	for i, ptx := range pending {
+		hashes[i] = ptx.tx.Hash()
+	}
+	receipts, errs := fetchReceipts(p.receipts, hashes)
+
+	for i, ptx := range pending {
+		status := PrivatePending
+		receipt, err := receipts[i], errs[i]
+		if err == nil && receipt != nil {
+			status = PrivateIncluded
+		} else if err != nil && err != ethereum.NotFound {
+			log.Printf("Failed to get receipt for %s: %s\n", ptx.tx.Hash().Hex(), err)
+		}
+		if status == PrivatePending && head >= ptx.maxBlock {
+			status = PrivateExpired
+		}
+
+		p.mx.Lock()
+		// Cancelled while the receipt was fetched
+		if ptx.status != PrivatePending {
+			p.mx.Unlock()
+			continue
+		}
+		ptx.status = status
+		p.mx.Unlock()
+
+		if status != PrivatePending {
+			log.Printf("Private transaction %s %s\n", ptx.tx.Hash().Hex(), status)
+			continue
+		}
+
+		_, err = p.deliverTx(ptx.tx, ptx.source)
+		if err != nil {
+			log.Printf("Failed to deliver %s for block %d: %s\n", ptx.tx.Hash().Hex(), head+1, err)
+		}
+	}
+}
+
+// eth_sendPrivateTransaction takes the raw transaction, an optional
+// maxBlockNumber and preferences
+func (p *PrivateBook) SendMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		if len(jsr.Params) != 1 {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected a private transaction"}
+		}
+
+		var args SendPrivateArgs
+		err := decodeParam(jsr.Params[0], &args)
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: fmt.Sprintf("Invalid private transaction: %s", err)}
+		}
+
+		tx, err := bt.ParseTransaction(args.Tx)
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: err.Error()}
+		}
+
+		maxBlock := uint64(0)
+		if args.MaxBlockNumber != nil {
+			maxBlock = uint64(*args.MaxBlockNumber)
+		}
+
+		rebate := args.Preferences.Rebate
+		if rebate == nil {
+			rebate, err = requestRebate(req)
+		} else {
+			err = rebate.validate()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := p.Send(tx, requestSource(req), maxBlock, rebate)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Private: %s\n", tx.Hash().Hex())
+		return result, nil
+	}
+}
+
+// eth_cancelPrivateTransaction takes the transaction hash and the sender's
+// signature of it and returns whether the transaction was cancelled
+func (p *PrivateBook) CancelMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		if len(jsr.Params) != 1 {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected a transaction hash"}
+		}
+
+		var args CancelPrivateArgs
+		err := decodeParam(jsr.Params[0], &args)
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: fmt.Sprintf("Invalid cancellation: %s", err)}
+		}
+
+		return p.Cancel(args.TxHash, args.Signature)
+	}
+}
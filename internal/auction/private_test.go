@@ -0,0 +1,96 @@
+package auction
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type stubReceipts struct {
+	included map[common.Hash]bool
+}
+
+func (s *stubReceipts) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	if !s.included[hash] {
+		return nil, ethereum.NotFound
+	}
+	return &types.Receipt{TxHash: hash}, nil
+}
+
+func cancelSignature(t *testing.T, tx *types.Transaction, key *ecdsa.PrivateKey) []byte {
+	signature, err := crypto.Sign(accounts.TextHash([]byte(tx.Hash().Hex())), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestPrivateBook(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	receipts := &stubReceipts{included: map[common.Hash]bool{}}
+	deliveries := map[common.Hash]int{}
+	deliver := func(tx *types.Transaction, source string) (string, error) {
+		deliveries[tx.Hash()]++
+		return tx.Hash().Hex(), nil
+	}
+	process := func(tx *types.Transaction, source string, rebate *Rebate) (string, error) {
+		return deliver(tx, source)
+	}
+	book := NewPrivateBook(blocks, receipts, process, deliver)
+
+	included, expiring, cancelled := testTx(t, 0, 1), testTx(t, 1, 1), testTx(t, 2, 1)
+
+	if _, err := book.Send(testTx(t, 3, 1), "", 100, nil); err == nil {
+		t.Error("expected a mined max block to be rejected")
+	}
+	for _, ptx := range []*types.Transaction{included, expiring, cancelled} {
+		if _, err := book.Send(ptx, "", 102, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the sender may cancel
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := book.Cancel(cancelled.Hash(), cancelSignature(t, cancelled, other)); ok || err == nil {
+		t.Fatal("expected a cancellation signed by someone else to be refused")
+	}
+	if ok, err := book.Cancel(cancelled.Hash(), nil); ok || err == nil {
+		t.Fatal("expected an unsigned cancellation to be refused")
+	}
+	if ok, err := book.Cancel(cancelled.Hash(), cancelSignature(t, cancelled, testKey)); !ok || err != nil {
+		t.Fatalf("expected the pending transaction to be cancelled, got %v", err)
+	}
+
+	chain.head = 101
+	blocks.poll()
+	receipts.included[included.Hash()] = true
+	chain.head = 102
+	blocks.poll()
+	chain.head = 103
+	blocks.poll()
+
+	if deliveries[included.Hash()] != 2 {
+		t.Errorf("expected the included transaction to stop after inclusion, got %d deliveries", deliveries[included.Hash()])
+	}
+	if deliveries[expiring.Hash()] != 2 {
+		t.Errorf("expected the expiring transaction to stop at its max block, got %d deliveries", deliveries[expiring.Hash()])
+	}
+	if deliveries[cancelled.Hash()] != 1 {
+		t.Errorf("expected the cancelled transaction not to be re-delivered, got %d deliveries", deliveries[cancelled.Hash()])
+	}
+	if ok, _ := book.Cancel(included.Hash(), cancelSignature(t, included, testKey)); ok {
+		t.Error("expected an included transaction not to be cancellable")
+	}
+}
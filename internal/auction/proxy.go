@@ -2,6 +2,7 @@ package auction
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -15,12 +16,18 @@ type Proxy struct {
 	handler http.Handler
 }
 
-func NewProxy(url *url.URL) *Proxy {
+// Requests are sent to whichever node in the pool is picked for them
+func NewProxy(pool *UpstreamPool) *Proxy {
+	url := pool.URL()
 	handler := httputil.NewSingleHostReverseProxy(url)
+	handler.Transport = pool
 
+	// Only reached once every node has failed
 	handler.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
 		log.Printf("vanillaProxyError: %s", err)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(types.NewJsError(types.CodeInternalError, "Vanilla node unavailable"))
 	}
 
 	return &Proxy{
@@ -38,11 +45,34 @@ func (p Proxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	p.handler.ServeHTTP(res, req)
 }
 
+// MockProxy answers every request with a null result
 type MockProxy struct{}
 
 func (mp MockProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	body := types.JsResponse{}
-	err := json.NewEncoder(res).Encode(body)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		log.Printf("Reading error %s", err)
+		return
+	}
+
+	batch, isBatch, err := types.ParseBatch(body)
+	if err != nil {
+		log.Printf("Parsing error %s", err)
+		return
+	}
+
+	responses := []types.JsResponse{}
+	for _, jsr := range batch {
+		if !jsr.IsNotification() {
+			responses = append(responses, types.NewJsResult(jsr.ID, nil))
+		}
+	}
+
+	var response interface{} = responses
+	if !isBatch {
+		response = types.NewJsResult(batch[0].ID, nil)
+	}
+	err = json.NewEncoder(res).Encode(response)
 	if err != nil {
 		log.Printf("Encoding error %s", err)
 	}
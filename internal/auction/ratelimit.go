@@ -0,0 +1,188 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Buckets are only pruned once a limiter tracks this many keys
+const rateLimitPruneSize = 10000
+
+var ErrRateLimited = &bt.JsError{Code: bt.CodeServerBusy, Message: "rate limit exceeded"}
+
+// RateLimit allows Burst requests at once, refilled at Rate per second
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+// RateBudgets are the limits applied to each key. Nil limits are not
+// enforced. Sender only applies to send methods.
+type RateBudgets struct {
+	IP     *RateLimit `json:"ip"`
+	Source *RateLimit `json:"source"`
+	Sender *RateLimit `json:"sender"`
+}
+
+// RateLimitConfig has separate budgets for intercepted send methods and
+// everything else, which is mostly proxied reads. The client IP is only
+// taken from X-Forwarded-For when TrustForwardedFor is set.
+type RateLimitConfig struct {
+	Send              RateBudgets `json:"send"`
+	Read              RateBudgets `json:"read"`
+	TrustForwardedFor bool        `json:"trustForwardedFor"`
+}
+
+func LoadRateLimitConfig(path string) (RateLimitConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+
+	var config RateLimitConfig
+	err = json.Unmarshal(contents, &config)
+	if err != nil {
+		return RateLimitConfig{}, fmt.Errorf("Failed to decode rate limits: %s", err)
+	}
+	return config, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// keyedLimiter keeps a token bucket per key
+type keyedLimiter struct {
+	limit   *RateLimit
+	mx      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedLimiter(limit *RateLimit) *keyedLimiter {
+	return &keyedLimiter{
+		limit:   limit,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+func (k *keyedLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * k.limit.Rate
+	if bucket.tokens > k.limit.Burst {
+		bucket.tokens = k.limit.Burst
+	}
+	bucket.last = now
+}
+
+func (k *keyedLimiter) Allow(key string, now time.Time) bool {
+	if k.limit == nil {
+		return true
+	}
+
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	if len(k.buckets) >= rateLimitPruneSize {
+		k.prune(now)
+	}
+
+	bucket, ok := k.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: k.limit.Burst, last: now}
+		k.buckets[key] = bucket
+	}
+	k.refill(bucket, now)
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Full buckets behave the same as missing ones so they can be dropped
+func (k *keyedLimiter) prune(now time.Time) {
+	for key, bucket := range k.buckets {
+		k.refill(bucket, now)
+		if bucket.tokens >= k.limit.Burst {
+			delete(k.buckets, key)
+		}
+	}
+}
+
+type rateLimiters struct {
+	ip     *keyedLimiter
+	source *keyedLimiter
+	sender *keyedLimiter
+}
+
+func newRateLimiters(budgets RateBudgets) rateLimiters {
+	return rateLimiters{
+		ip:     newKeyedLimiter(budgets.IP),
+		source: newKeyedLimiter(budgets.Source),
+		sender: newKeyedLimiter(budgets.Sender),
+	}
+}
+
+// RateLimiter applies the configured budgets to requests and transaction
+// senders. The zero config allows everything.
+type RateLimiter struct {
+	send              rateLimiters
+	read              rateLimiters
+	trustForwardedFor bool
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		send:              newRateLimiters(config.Send),
+		read:              newRateLimiters(config.Read),
+		trustForwardedFor: config.TrustForwardedFor,
+	}
+}
+
+func (r *RateLimiter) clientIP(req *http.Request) string {
+	if r.trustForwardedFor {
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// AllowRequest charges one request from the client's IP and source
+func (r *RateLimiter) AllowRequest(req *http.Request, send bool) bool {
+	limiters := r.read
+	if send {
+		limiters = r.send
+	}
+
+	source := requestSource(req)
+	if source == "" {
+		source = defaultSource
+	}
+
+	now := time.Now()
+	return limiters.ip.Allow(r.clientIP(req), now) && limiters.source.Allow(source, now)
+}
+
+// AllowSender charges one transaction from its sender. Transactions
+// without a valid signature are left to validation.
+func (r *RateLimiter) AllowSender(tx *types.Transaction) bool {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return true
+	}
+	return r.send.sender.Allow(from.Hex(), time.Now())
+}
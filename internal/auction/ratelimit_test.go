@@ -0,0 +1,70 @@
+package auction
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/nukowsk/bukowskis/internal/sender"
+	"github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func TestRateLimits(t *testing.T) {
+	local, _ := store.NewLocal()
+	limiter := NewRateLimiter(RateLimitConfig{
+		Send: RateBudgets{IP: &RateLimit{Rate: 0, Burst: 1}},
+		Read: RateBudgets{Source: &RateLimit{Rate: 0, Burst: 2}},
+	})
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		NewStatusTracker(local, "mock"),
+		sender.MockSender{},
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		limiter,
+		nil)
+
+	raw, hash := testRawTx(t, 600)
+	send := `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["` + raw + `"],"id":1}`
+	read := `{"jsonrpc":"2.0","method":"eth_blockNumber","id":2}`
+	limited := `"code":-32005,"message":"rate limit exceeded"`
+
+	if got := serve(h, send); !strings.Contains(got, hash) {
+		t.Fatalf("expected the first send to be accepted, got %s", got)
+	}
+	if got := serve(h, send); !strings.Contains(got, limited) {
+		t.Errorf("expected the second send to be limited, got %s", got)
+	}
+
+	// Reads have their own budget
+	got := serve(h, "["+read+","+read+","+read+"]")
+	if strings.Count(got, `"result":null`) != 2 || strings.Count(got, limited) != 1 {
+		t.Errorf("expected the third read to be limited, got %s", got)
+	}
+}
+
+func TestSenderRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		Send: RateBudgets{Sender: &RateLimit{Rate: 0, Burst: 1}},
+	})
+	raw, _ := testRawTx(t, 600)
+	otherRaw, _ := testRawTx(t, 600)
+	tx, err := bt.ParseTransaction(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := bt.ParseTransaction(otherRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !limiter.AllowSender(tx) || limiter.AllowSender(tx) {
+		t.Error("expected the sender to be limited after one transaction")
+	}
+	if !limiter.AllowSender(other) {
+		t.Error("expected another sender to have its own budget")
+	}
+}
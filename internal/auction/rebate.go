@@ -0,0 +1,77 @@
+package auction
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Rebate is the user behind a transaction and the percentage of the
+// transaction's share of the winning bid they earn. Wallets routing many
+// users through one source attach a rebate to each of their transactions.
+type Rebate struct {
+	Recipient common.Address `json:"recipient"`
+	Percent   uint64         `json:"percent"`
+}
+
+func (r *Rebate) validate() error {
+	if r.Recipient == (common.Address{}) {
+		return &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid rebate, missing recipient"}
+	}
+	if r.Percent == 0 || r.Percent > 100 {
+		return &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid rebate, percent should be between 1 and 100"}
+	}
+	return nil
+}
+
+// Rebates are set with the rebateRecipient and rebatePercent query
+// parameters on the RPC URL, alongside source. Returns nil when there is
+// no rebate.
+func requestRebate(req *http.Request) (*Rebate, error) {
+	query := req.URL.Query()
+	recipient := query.Get("rebateRecipient")
+	if recipient == "" {
+		return nil, nil
+	}
+	if !common.IsHexAddress(recipient) {
+		return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid rebate, recipient should be an address"}
+	}
+	percent, err := strconv.ParseUint(query.Get("rebatePercent"), 10, 64)
+	if err != nil {
+		return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid rebate, percent should be a number"}
+	}
+
+	rebate := &Rebate{
+		Recipient: common.HexToAddress(recipient),
+		Percent:   percent,
+	}
+	return rebate, rebate.validate()
+}
+
+// A rebate passed as a parameter to a send method takes precedence over
+// the one on the RPC URL
+func paramRebate(req *http.Request, param interface{}) (*Rebate, error) {
+	if param == nil {
+		return requestRebate(req)
+	}
+	var rebate Rebate
+	err := decodeParam(param, &rebate)
+	if err != nil {
+		return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: fmt.Sprintf("Invalid rebate: %s", err)}
+	}
+	return &rebate, rebate.validate()
+}
+
+func entryRebate(entry *st.LogEntry) *Rebate {
+	if entry.RebateRecipient == "" {
+		return nil
+	}
+	return &Rebate{
+		Recipient: common.HexToAddress(entry.RebateRecipient),
+		Percent:   entry.RebatePercent,
+	}
+}
@@ -0,0 +1,72 @@
+package auction
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nukowsk/bukowskis/internal/sender"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func TestRebate(t *testing.T) {
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(local, "mock")
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		tracker,
+		sender.MockSender{},
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
+	recipient := common.HexToAddress("0x01")
+	send := func(url string, params string) bt.JsResponse {
+		body := `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":[` + params + `],"id":1}`
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("POST", url, strings.NewReader(body)))
+		var response bt.JsResponse
+		if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	rebateOf := func(hash string) *Rebate {
+		status, err := tracker.Status(hash)
+		if err != nil || status == nil {
+			t.Fatalf("expected a status for %s, got %v", hash, err)
+		}
+		return status.Rebate
+	}
+
+	raw, hash := testRawTx(t, 600)
+	send("/?rebateRecipient="+recipient.Hex()+"&rebatePercent=30", `"`+raw+`"`)
+	if rebate := rebateOf(hash); rebate == nil || rebate.Recipient != recipient || rebate.Percent != 30 {
+		t.Errorf("expected the URL rebate, got %+v", rebate)
+	}
+
+	// The parameter overrides the URL
+	raw, hash = testRawTx(t, 600)
+	send("/?rebateRecipient=0x02&rebatePercent=30", `"`+raw+`",{"recipient":"`+recipient.Hex()+`","percent":80}`)
+	if rebate := rebateOf(hash); rebate == nil || rebate.Recipient != recipient || rebate.Percent != 80 {
+		t.Errorf("expected the parameter's rebate, got %+v", rebate)
+	}
+
+	raw, hash = testRawTx(t, 600)
+	send("/", `"`+raw+`"`)
+	if rebate := rebateOf(hash); rebate != nil {
+		t.Errorf("expected no rebate, got %+v", rebate)
+	}
+
+	raw, _ = testRawTx(t, 600)
+	response := send("/?rebateRecipient="+recipient.Hex()+"&rebatePercent=101", `"`+raw+`"`)
+	if response.Error == nil || response.Error.Code != bt.CodeInvalidParams {
+		t.Errorf("expected an invalid rebate to be refused, got %+v", response)
+	}
+}
@@ -0,0 +1,244 @@
+package auction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+const (
+	ReservationReserved  = "reserved"
+	ReservationReleased  = "released"
+	ReservationCancelled = "cancelled"
+	ReservationFailed    = "failed"
+)
+
+const (
+	// Finished reservations are forgotten this many blocks after their
+	// target
+	reservationRetention = 256
+
+	// Targets can be at most this many blocks ahead of the head
+	reservationMaxBlocks = 128
+)
+
+// Reservation is a transaction held until its target block is auctioned
+type Reservation struct {
+	Hash        string         `json:"hash"`
+	TargetBlock hexutil.Uint64 `json:"targetBlock"`
+	Status      string         `json:"status"`
+	Result      string         `json:"result,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	tx          *types.Transaction
+	source      string
+	rebate      *Rebate
+}
+
+// ReserveBook holds reserved transactions and releases each one to the
+// winner of its target block once the chain reaches the block before it
+type ReserveBook struct {
+	mx           sync.Mutex
+	reservations map[string]*Reservation
+	blocks       *BlockWatcher
+	checkTx      func(*types.Transaction, string) error
+	processTx    func(*types.Transaction, string, *Rebate) (string, error)
+}
+
+// checkTx runs the admission checks when a transaction is reserved,
+// processTx admits and delivers it at the target block
+func NewReserveBook(
+	blocks *BlockWatcher,
+	checkTx func(*types.Transaction, string) error,
+	processTx func(*types.Transaction, string, *Rebate) (string, error)) *ReserveBook {
+	r := &ReserveBook{
+		reservations: map[string]*Reservation{},
+		blocks:       blocks,
+		checkTx:      checkTx,
+		processTx:    processTx,
+	}
+	blocks.Subscribe(r.onBlock)
+	return r
+}
+
+func (r *ReserveBook) Reserve(tx *types.Transaction, source string, target uint64, rebate *Rebate) error {
+	head := r.blocks.Head()
+	if head == 0 || target <= head+1 {
+		return fmt.Errorf("Target block %d must be after the next block", target)
+	}
+	if target > head+reservationMaxBlocks {
+		return fmt.Errorf("Target block %d is more than %d blocks ahead", target, reservationMaxBlocks)
+	}
+
+	err := r.checkTx(tx, source)
+	if err != nil {
+		return err
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	hash := tx.Hash().Hex()
+	if _, ok := r.reservations[hash]; ok {
+		return fmt.Errorf("Transaction %s already reserved", hash)
+	}
+
+	r.reservations[hash] = &Reservation{
+		Hash:        hash,
+		TargetBlock: hexutil.Uint64(target),
+		Status:      ReservationReserved,
+		tx:          tx,
+		source:      source,
+		rebate:      rebate,
+	}
+	return nil
+}
+
+// Cancel withdraws a reservation which hasn't been released yet
+func (r *ReserveBook) Cancel(hash string) error {
+	hash = common.HexToHash(hash).Hex()
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	reservation, ok := r.reservations[hash]
+	if !ok {
+		return fmt.Errorf("Unknown reservation %s", hash)
+	}
+	if reservation.Status != ReservationReserved {
+		return fmt.Errorf("Reservation %s is already %s", hash, reservation.Status)
+	}
+	reservation.Status = ReservationCancelled
+	return nil
+}
+
+func (r *ReserveBook) Get(hash string) (Reservation, bool) {
+	hash = common.HexToHash(hash).Hex()
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	reservation, ok := r.reservations[hash]
+	if !ok {
+		return Reservation{}, false
+	}
+	return *reservation, true
+}
+
+func (r *ReserveBook) onBlock(head uint64) {
+	due := []*Reservation{}
+	r.mx.Lock()
+	for hash, reservation := range r.reservations {
+		target := uint64(reservation.TargetBlock)
+		if reservation.Status == ReservationReserved && target <= head+1 {
+			due = append(due, reservation)
+		} else if reservation.Status != ReservationReserved && target+reservationRetention < head {
+			delete(r.reservations, hash)
+		}
+	}
+	r.mx.Unlock()
+
+	for _, reservation := range due {
+		// Marked released first so it can no longer be cancelled
+		r.mx.Lock()
+		if reservation.Status != ReservationReserved {
+			r.mx.Unlock()
+			continue
+		}
+		reservation.Status = ReservationReleased
+		r.mx.Unlock()
+
+		log.Printf("Releasing %s for block %d\n", reservation.Hash, reservation.TargetBlock)
+		result, err := r.processTx(reservation.tx, reservation.source, reservation.rebate)
+
+		r.mx.Lock()
+		if err != nil {
+			log.Printf("Failed to release %s: %s\n", reservation.Hash, err)
+			reservation.Status = ReservationFailed
+			reservation.Error = err.Error()
+		} else {
+			reservation.Result = result
+		}
+		r.mx.Unlock()
+	}
+}
+
+func hashParam(jsr bt.JsRequest) (string, error) {
+	if len(jsr.Params) != 1 {
+		return "", &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected a transaction hash"}
+	}
+	hash, ok := jsr.Params[0].(string)
+	if !ok {
+		return "", &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, hash should be a string"}
+	}
+	return hash, nil
+}
+
+// eth_sendRawTransaction_reserve takes the raw transaction and the target
+// block number
+func (r *ReserveBook) SendMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		if len(jsr.Params) != 2 {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: "Invalid Request, expected transaction and target block"}
+		}
+
+		tx, err := bt.ExtractTransaction(bt.JsRequest{Params: jsr.Params[:1]})
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: err.Error()}
+		}
+
+		target, err := parseBlockNumber(jsr.Params[1])
+		if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeInvalidParams, Message: err.Error()}
+		}
+
+		rebate, err := requestRebate(req)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.Reserve(tx, requestSource(req), target, rebate)
+		var jsErr *bt.JsError
+		if errors.As(err, &jsErr) || isNodeError(err) {
+			return nil, err
+		} else if err != nil {
+			return nil, &bt.JsError{Code: bt.CodeTransactionRejected, Message: err.Error()}
+		}
+
+		log.Printf("Reserved: %s for block %d\n", tx.Hash().Hex(), target)
+		return tx.Hash().Hex(), nil
+	}
+}
+
+// bukowskis_cancelReservation takes the transaction hash. The hash is
+// only known to the submitter while the transaction is held.
+func (r *ReserveBook) CancelMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		hash, err := hashParam(jsr)
+		if err != nil {
+			return nil, err
+		}
+		err = r.Cancel(hash)
+		if err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+}
+
+// bukowskis_getReservation takes the transaction hash and returns null for
+// unknown reservations
+func (r *ReserveBook) StatusMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		hash, err := hashParam(jsr)
+		if err != nil {
+			return nil, err
+		}
+		reservation, ok := r.Get(hash)
+		if !ok {
+			return nil, nil
+		}
+		return reservation, nil
+	}
+}
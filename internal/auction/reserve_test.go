@@ -0,0 +1,82 @@
+package auction
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type stubBlocks struct {
+	head uint64
+}
+
+func (s *stubBlocks) BlockNumber(ctx context.Context) (uint64, error) {
+	return s.head, nil
+}
+
+func TestReserveBook(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	released := []string{}
+	check := func(tx *types.Transaction, source string) error {
+		if tx.Nonce() == 3 {
+			return errors.New("nonce too low")
+		}
+		return nil
+	}
+	book := NewReserveBook(blocks, check, func(tx *types.Transaction, source string, rebate *Rebate) (string, error) {
+		released = append(released, tx.Hash().Hex())
+		return tx.Hash().Hex(), nil
+	})
+
+	held, cancelled := testTx(t, 0, 1), testTx(t, 1, 1)
+
+	if err := book.Reserve(testTx(t, 2, 1), "", 101, nil); err == nil {
+		t.Error("expected the next block to be rejected")
+	}
+	if err := book.Reserve(testTx(t, 2, 1), "", 101+reservationMaxBlocks, nil); err == nil {
+		t.Error("expected a far future block to be rejected")
+	}
+	if err := book.Reserve(testTx(t, 3, 1), "", 105, nil); err == nil {
+		t.Error("expected an invalid transaction to be rejected when reserved")
+	}
+	if err := book.Reserve(held, "", 105, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Reserve(cancelled, "", 105, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Cancel("0x" + strings.ToUpper(cancelled.Hash().Hex()[2:])); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.head = 103
+	blocks.poll()
+	if len(released) != 0 {
+		t.Fatalf("released before target: %v", released)
+	}
+
+	chain.head = 104
+	blocks.poll()
+	if len(released) != 1 || released[0] != held.Hash().Hex() {
+		t.Fatalf("expected only the held transaction, got %v", released)
+	}
+
+	reservation, _ := book.Get(held.Hash().Hex())
+	if reservation.Status != ReservationReleased {
+		t.Errorf("expected released, got %s", reservation.Status)
+	}
+	reservation, _ = book.Get(cancelled.Hash().Hex())
+	if reservation.Status != ReservationCancelled {
+		t.Errorf("expected cancelled, got %s", reservation.Status)
+	}
+	if err := book.Cancel(held.Hash().Hex()); err == nil {
+		t.Error("expected released reservation to be final")
+	}
+}
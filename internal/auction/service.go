@@ -7,13 +7,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/nukowsk/bukowskis/internal/sender"
-	"github.com/nukowsk/bukowskis/internal/store"
 )
 
 type AuctionService struct {
-	mx     sync.Mutex
-	server *http.Server
+	mx      sync.Mutex
+	mux     *http.ServeMux
+	handler *Handler
+	server  *http.Server
+	stopped chan struct{}
 }
 
 // XXX: This can probably just be called Service in the acution package
@@ -21,27 +24,86 @@ func NewAuctionService(
 	port string,
 	proxy http.Handler,
 	sender sender.Sender,
-	store store.Store,
-	gasGetter GasGetter) (*AuctionService, error) { // XXX: remove error?
+	tracker *StatusTracker,
+	gasGetter GasGetter,
+	hints HintPolicies,
+	gasPolicies GasPolicies,
+	validator Validator,
+	limiter *RateLimiter,
+	pool *PendingPool) (*AuctionService, error) { // XXX: remove error?
 
-	handler := NewHandler(gasGetter, store, sender, proxy)
-	server := &http.Server{Addr: ":" + port, Handler: handler}
+	handler := NewHandler(gasGetter, tracker, sender, proxy, hints, gasPolicies, validator, limiter, pool)
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	server := &http.Server{Addr: ":" + port, Handler: mux}
 	return &AuctionService{
-		mx:     sync.Mutex{},
-		server: server,
+		mx:      sync.Mutex{},
+		mux:     mux,
+		handler: handler,
+		server:  server,
+		stopped: make(chan struct{}),
 	}, nil
 
 }
 
+// Mount an additional endpoint alongside the JSON-RPC handler. Must be
+// called before Run.
+func (t *AuctionService) Handle(pattern string, handler http.Handler) {
+	t.mux.Handle(pattern, handler)
+}
+
+func (t *AuctionService) ProcessTransaction(tx *types.Transaction, source string, rebate *Rebate) (string, error) {
+	return t.handler.ProcessTransaction(tx, source, rebate)
+}
+
+func (t *AuctionService) CheckTransaction(tx *types.Transaction, source string) error {
+	return t.handler.CheckTransaction(tx, source)
+}
+
+func (t *AuctionService) DeliverTransaction(tx *types.Transaction, source string) (string, error) {
+	return t.handler.DeliverTransaction(tx, source)
+}
+
+// Answer a JSON-RPC method in the auction instead of proxying it. Must be
+// called before Run.
+func (t *AuctionService) Register(method string, fn MethodFunc) {
+	t.handler.Register(method, fn)
+}
+
+// Serve JSON-RPC over websockets on pattern, proxying to the vanilla
+// node's websocket at upstream for at most maxConnections clients at once.
+// Must be called before Run.
+func (t *AuctionService) HandleWebsocket(pattern string, upstream string, maxConnections int) {
+	t.mux.Handle(pattern, NewWebsocketFrontend(t.handler, upstream, maxConnections))
+}
+
+// Replace the default policy for proxied methods. Must be called before
+// Run.
+func (t *AuctionService) SetMethodPolicy(policy MethodPolicy) {
+	t.handler.SetMethodPolicy(policy)
+}
+
+// Answer a JSON-RPC method which submits transactions. Must be called
+// before Run.
+func (t *AuctionService) RegisterSend(method string, fn MethodFunc) {
+	t.handler.RegisterSend(method, fn)
+}
+
+// Run returns when the server fails to listen, or once Stop has finished
+// the requests in flight
 func (t *AuctionService) Run() {
-	if err := t.server.ListenAndServe(); err != nil {
+	err := t.server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		log.Println(err)
+		return
 	}
+	<-t.stopped
 }
 
 func (t *AuctionService) Stop() {
 	t.mx.Lock()
 	defer t.mx.Unlock()
+	defer close(t.stopped)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := t.server.Shutdown(ctx); err != nil {
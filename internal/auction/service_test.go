@@ -25,10 +25,15 @@ func TestInitialization(t *testing.T) {
 		port,
 		proxy,
 		thissender,
-		store,
+		NewStatusTracker(store, "mock"),
 		&MockGasGetter{
 			price: big.NewInt(400),
-		})
+		},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
 
 	if err != nil {
 		t.Errorf("Auction service init failed failed %s\n", err)
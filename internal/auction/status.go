@@ -0,0 +1,307 @@
+package auction
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	st "github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Delivered transactions which aren't included this many blocks after the
+// block they were last auctioned for are dropped
+const dropAfterBlocks = 25
+
+// StatusTracker records the lifecycle of each transaction in the store
+type StatusTracker struct {
+	store    st.Store
+	bidder   string
+	mx       sync.Mutex
+	blocks   *BlockWatcher
+	receipts ReceiptGetter
+	pending  map[common.Hash]*st.LogEntry
+}
+
+// bidder names the winner transactions are delivered to
+func NewStatusTracker(store st.Store, bidder string) *StatusTracker {
+	return &StatusTracker{
+		store:   store,
+		bidder:  bidder,
+		pending: map[common.Hash]*st.LogEntry{},
+	}
+}
+
+// Watch checks delivered transactions for inclusion on every block. Must
+// be called before transactions are delivered.
+func (s *StatusTracker) Watch(blocks *BlockWatcher, receipts ReceiptGetter) {
+	s.blocks = blocks
+	s.receipts = receipts
+	blocks.Subscribe(s.onBlock)
+}
+
+// Received records an admitted transaction and who earns from it, rebate
+// may be nil. A resubmission replaces an earlier rejection or drop, but
+// not a delivery or inclusion.
+func (s *StatusTracker) Received(tx *types.Transaction, rebate *Rebate) error {
+	existing, err := s.store.Get(tx.Hash().Hex())
+	if err != nil {
+		return err
+	}
+
+	entry, err := st.NewLogEntry(tx)
+	if err != nil {
+		return err
+	}
+	if rebate != nil {
+		entry.RebateRecipient = rebate.Recipient.Hex()
+		entry.RebatePercent = rebate.Percent
+	}
+	if existing == nil {
+		return s.store.Save(&entry)
+	}
+	switch existing.Status {
+	case st.StatusQueued, st.StatusDelivered, st.StatusIncluded:
+		return nil
+	}
+	entry.Timestamp = existing.Timestamp
+	return s.store.Update(&entry)
+}
+
+// Rejected records why a transaction was refused. Transactions which have
+// already been delivered are left alone, a resubmission being rejected
+// doesn't change what happened to the original.
+func (s *StatusTracker) Rejected(tx *types.Transaction, reason error) {
+	entry, err := s.store.Get(tx.Hash().Hex())
+	if err != nil {
+		log.Printf("Failed to get status of %s: %s\n", tx.Hash().Hex(), err)
+		return
+	}
+
+	if entry == nil {
+		created, err := st.NewLogEntry(tx)
+		if err != nil {
+			log.Printf("Failed to create log entry: %s\n", err)
+			return
+		}
+		entry = &created
+	} else if entry.Status != st.StatusReceived {
+		return
+	}
+
+	entry.Status = st.StatusRejected
+	entry.Reason = reason.Error()
+	entry.Updated = time.Now()
+	err = s.store.Update(entry)
+	if err != nil {
+		log.Printf("Failed to store status of %s: %s\n", tx.Hash().Hex(), err)
+	}
+}
+
+// Delivered records the block the transaction was auctioned for and the
+// winner it was sent to. Called again every time it is re-delivered.
+func (s *StatusTracker) Delivered(tx *types.Transaction) {
+	s.dispatched(tx, st.StatusDelivered)
+}
+
+// Queued is Delivered for senders which send later, they report the
+// outcome through Delivered or Failed. Must be called before the delivery
+// is handed to the sender.
+func (s *StatusTracker) Queued(tx *types.Transaction) {
+	s.dispatched(tx, st.StatusQueued)
+}
+
+// Failed records a queued transaction which couldn't be sent to the winner
+func (s *StatusTracker) Failed(tx *types.Transaction, reason error) {
+	s.mx.Lock()
+	delete(s.pending, tx.Hash())
+	s.mx.Unlock()
+
+	entry, err := s.store.Get(tx.Hash().Hex())
+	if err != nil || entry == nil {
+		log.Printf("Failed to get status of %s: %v\n", tx.Hash().Hex(), err)
+		return
+	}
+	if entry.Status != st.StatusQueued {
+		return
+	}
+
+	entry.Status = st.StatusRejected
+	entry.Reason = "delivery failed: " + reason.Error()
+	entry.Updated = time.Now()
+	err = s.store.Update(entry)
+	if err != nil {
+		log.Printf("Failed to store status of %s: %s\n", tx.Hash().Hex(), err)
+	}
+}
+
+func (s *StatusTracker) dispatched(tx *types.Transaction, status string) {
+	s.mx.Lock()
+	entry, ok := s.pending[tx.Hash()]
+	s.mx.Unlock()
+	if !ok {
+		var err error
+		entry, err = s.store.Get(tx.Hash().Hex())
+		if err != nil || entry == nil {
+			log.Printf("Failed to get status of %s: %v\n", tx.Hash().Hex(), err)
+			return
+		}
+	}
+
+	auctionBlock := uint64(0)
+	if s.blocks != nil {
+		auctionBlock = s.blocks.Head() + 1
+	}
+
+	s.mx.Lock()
+	entry.Status = status
+	entry.Reason = ""
+	entry.Bidder = s.bidder
+	entry.AuctionBlock = auctionBlock
+	entry.Updated = time.Now()
+	update := *entry
+	if s.receipts != nil {
+		s.pending[tx.Hash()] = entry
+	}
+	s.mx.Unlock()
+
+	err := s.store.Update(&update)
+	if err != nil {
+		log.Printf("Failed to store status of %s: %s\n", tx.Hash().Hex(), err)
+	}
+}
+
+// Dropped records a transaction given up on before it was included.
+// Rejected and included transactions are left alone.
+func (s *StatusTracker) Dropped(tx *types.Transaction, reason string) {
+	s.mx.Lock()
+	delete(s.pending, tx.Hash())
+	s.mx.Unlock()
+
+	entry, err := s.store.Get(tx.Hash().Hex())
+	if err != nil || entry == nil {
+		log.Printf("Failed to get status of %s: %v\n", tx.Hash().Hex(), err)
+		return
+	}
+	switch entry.Status {
+	case st.StatusReceived, st.StatusQueued, st.StatusDelivered:
+	default:
+		return
+	}
+
+	entry.Status = st.StatusDropped
+	entry.Reason = reason
+	entry.Updated = time.Now()
+	err = s.store.Update(entry)
+	if err != nil {
+		log.Printf("Failed to store status of %s: %s\n", tx.Hash().Hex(), err)
+	}
+}
+
+func (s *StatusTracker) onBlock(head uint64) {
+	s.mx.Lock()
+	pending := []st.LogEntry{}
+	hashes := []common.Hash{}
+	for hash, entry := range s.pending {
+		pending = append(pending, *entry)
+		hashes = append(hashes, hash)
+	}
+	s.mx.Unlock()
+
+	receipts, errs := fetchReceipts(s.receipts, hashes)
+	for i, entry := range pending {
+		hash := hashes[i]
+		receipt, err := receipts[i], errs[i]
+		if err != nil && err != ethereum.NotFound {
+			log.Printf("Failed to get receipt for %s: %s\n", hash.Hex(), err)
+			continue
+		}
+
+		if err == nil && receipt != nil {
+			entry.Status = st.StatusIncluded
+			entry.IncludedBlock = receipt.BlockNumber.Uint64()
+		} else if head > entry.AuctionBlock+dropAfterBlocks {
+			entry.Status = st.StatusDropped
+			entry.Reason = "not included"
+		} else {
+			continue
+		}
+
+		s.mx.Lock()
+		// Re-delivered while the receipt was fetched
+		if current, ok := s.pending[hash]; ok && current.AuctionBlock != entry.AuctionBlock {
+			s.mx.Unlock()
+			continue
+		}
+		delete(s.pending, hash)
+		s.mx.Unlock()
+
+		entry.Updated = time.Now()
+		err = s.store.Update(&entry)
+		if err != nil {
+			log.Printf("Failed to store status of %s: %s\n", hash.Hex(), err)
+		}
+	}
+}
+
+type TransactionStatus struct {
+	Hash          string          `json:"hash"`
+	Status        string          `json:"status"`
+	Reason        string          `json:"reason,omitempty"`
+	AuctionBlock  *hexutil.Uint64 `json:"auctionBlock,omitempty"`
+	Bidder        string          `json:"bidder,omitempty"`
+	IncludedBlock *hexutil.Uint64 `json:"includedBlock,omitempty"`
+	Rebate        *Rebate         `json:"rebate,omitempty"`
+	Received      time.Time       `json:"received"`
+	Updated       time.Time       `json:"updated"`
+}
+
+func blockOrNil(block uint64) *hexutil.Uint64 {
+	if block == 0 {
+		return nil
+	}
+	b := hexutil.Uint64(block)
+	return &b
+}
+
+func (s *StatusTracker) Status(hash string) (*TransactionStatus, error) {
+	entry, err := s.store.Get(common.HexToHash(hash).Hex())
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return &TransactionStatus{
+		Hash:          entry.Transaction,
+		Status:        entry.Status,
+		Reason:        entry.Reason,
+		AuctionBlock:  blockOrNil(entry.AuctionBlock),
+		Bidder:        entry.Bidder,
+		IncludedBlock: blockOrNil(entry.IncludedBlock),
+		Rebate:        entryRebate(entry),
+		Received:      entry.Timestamp,
+		Updated:       entry.Updated,
+	}, nil
+}
+
+// bukowskis_getTransactionStatus takes the transaction hash and returns
+// null for unknown transactions
+func (s *StatusTracker) StatusMethod() MethodFunc {
+	return func(req *http.Request, jsr bt.JsRequest) (interface{}, error) {
+		hash, err := hashParam(jsr)
+		if err != nil {
+			return nil, err
+		}
+		status, err := s.Status(hash)
+		if err != nil {
+			return nil, err
+		}
+		if status == nil {
+			return nil, nil
+		}
+		return status, nil
+	}
+}
@@ -0,0 +1,202 @@
+package auction
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nukowsk/bukowskis/internal/sender"
+	st "github.com/nukowsk/bukowskis/internal/store"
+)
+
+type receiptsWithBlock struct {
+	stubReceipts
+	block int64
+}
+
+func (r *receiptsWithBlock) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, err := r.stubReceipts.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	receipt.BlockNumber = big.NewInt(r.block)
+	return receipt, nil
+}
+
+func TestStatusTracker(t *testing.T) {
+	chain := &stubBlocks{head: 100}
+	blocks := NewBlockWatcher(chain, time.Second)
+	blocks.poll()
+
+	local, _ := st.NewLocal()
+	receipts := &receiptsWithBlock{stubReceipts: stubReceipts{included: map[common.Hash]bool{}}}
+	tracker := NewStatusTracker(local, "bidder")
+	tracker.Watch(blocks, receipts)
+
+	included, dropped, rejected := testTx(t, 0, 1), testTx(t, 1, 1), testTx(t, 2, 1)
+
+	tracker.Rejected(rejected, errors.New("nonce too low"))
+	for _, ptx := range []*types.Transaction{included, dropped} {
+		if err := tracker.Received(ptx, nil); err != nil {
+			t.Fatal(err)
+		}
+		tracker.Delivered(ptx)
+	}
+
+	status, _ := tracker.Status(dropped.Hash().Hex())
+	if status.Status != st.StatusDelivered || uint64(*status.AuctionBlock) != 101 || status.Bidder != "bidder" {
+		t.Errorf("expected delivery for block 101 to bidder, got %+v", status)
+	}
+
+	receipts.included[included.Hash()] = true
+	receipts.block = 101
+	chain.head = 101
+	blocks.poll()
+	chain.head = 101 + dropAfterBlocks + 1
+	blocks.poll()
+
+	expected := map[common.Hash]string{
+		included.Hash(): st.StatusIncluded,
+		dropped.Hash():  st.StatusDropped,
+		rejected.Hash(): st.StatusRejected,
+	}
+	for hash, want := range expected {
+		status, err := tracker.Status(hash.Hex())
+		if err != nil || status == nil {
+			t.Fatalf("missing status for %s: %v", hash.Hex(), err)
+		}
+		if status.Status != want {
+			t.Errorf("expected %s, got %+v", want, status)
+		}
+	}
+
+	status, _ = tracker.Status(included.Hash().Hex())
+	if status.IncludedBlock == nil || uint64(*status.IncludedBlock) != 101 {
+		t.Errorf("expected inclusion in block 101, got %+v", status)
+	}
+	status, _ = tracker.Status(rejected.Hash().Hex())
+	if status.Reason != "nonce too low" {
+		t.Errorf("expected the rejection reason, got %+v", status)
+	}
+
+	// A rejected resubmission doesn't change what happened to the original
+	tracker.Rejected(included, errors.New("nonce too low"))
+	status, _ = tracker.Status(included.Hash().Hex())
+	if status.Status != st.StatusIncluded {
+		t.Errorf("expected the included status to be kept, got %+v", status)
+	}
+
+	if status, _ := tracker.Status(common.Hash{}.Hex()); status != nil {
+		t.Errorf("expected no status for an unknown transaction, got %+v", status)
+	}
+}
+
+// createOnlyStore refuses to save a transaction twice, like Firestore
+type createOnlyStore struct {
+	*st.Local
+}
+
+func (c createOnlyStore) Save(entry *st.LogEntry) error {
+	if existing, _ := c.Get(entry.Transaction); existing != nil {
+		return errors.New("already exists")
+	}
+	return c.Local.Save(entry)
+}
+
+func TestStatusTrackerResubmission(t *testing.T) {
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(createOnlyStore{local}, "bidder")
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+	tracker.Rejected(tx, errors.New("gas too low"))
+	if err := tracker.Received(tx, nil); err != nil {
+		t.Fatalf("expected a rejected transaction to be resubmitted, got %s", err)
+	}
+	status, _ := tracker.Status(tx.Hash().Hex())
+	if status.Status != st.StatusReceived || status.Reason != "" {
+		t.Errorf("expected the rejection to be replaced, got %+v", status)
+	}
+
+	tracker.Delivered(tx)
+	if err := tracker.Received(tx, nil); err != nil {
+		t.Fatalf("expected a delivered transaction to be resubmitted, got %s", err)
+	}
+	status, _ = tracker.Status(tx.Hash().Hex())
+	if status.Status != st.StatusDelivered {
+		t.Errorf("expected the delivery to be kept, got %+v", status)
+	}
+}
+
+func TestStatusTrackerQueued(t *testing.T) {
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(local, "bidder")
+	failed, sent := testTx(t, 0, 1), testTx(t, 1, 1)
+	for _, ptx := range []*types.Transaction{failed, sent} {
+		if err := tracker.Received(ptx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker.Queued(failed)
+	if status, _ := tracker.Status(failed.Hash().Hex()); status.Status != st.StatusQueued {
+		t.Errorf("expected queued, got %+v", status)
+	}
+	tracker.Failed(failed, errors.New("unreachable"))
+
+	tracker.Queued(sent)
+	tracker.Delivered(sent)
+
+	expected := map[common.Hash]string{
+		failed.Hash(): st.StatusRejected,
+		sent.Hash():   st.StatusDelivered,
+	}
+	for hash, want := range expected {
+		if status, _ := tracker.Status(hash.Hex()); status.Status != want {
+			t.Errorf("expected %s, got %+v", want, status)
+		}
+	}
+}
+
+type failingSender struct{}
+
+func (f failingSender) Send(tx *types.Transaction) (string, error) {
+	return "", errors.New("unreachable")
+}
+
+// The worker can fail the delivery before Send returns to the handler
+func TestStatusTrackerQueuedFailure(t *testing.T) {
+	local, _ := st.NewLocal()
+	tracker := NewStatusTracker(local, "bidder")
+	queued := sender.NewQueuedSender(failingSender{}, 1, 1)
+	go queued.Run()
+	defer queued.Stop()
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		tracker,
+		queued,
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		MockValidator{},
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
+
+	raw, hash := testRawTx(t, 600)
+	serve(h, `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["`+raw+`"],"id":1}`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := tracker.Status(hash)
+		if status != nil && status.Status == st.StatusRejected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the failed delivery to be rejected, got %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,282 @@
+package auction
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Host of the placeholder URL given to clients of the pool, the transport
+// replaces it with a real upstream
+const poolHost = "upstream-pool"
+
+// Upstream is a vanilla node in the pool
+type Upstream struct {
+	url     *url.URL
+	weight  int
+	client  *rpc.Client
+	healthy bool
+	head    uint64
+	latency time.Duration
+}
+
+// UpstreamPool spreads requests over several vanilla nodes by weight.
+// Health checks poll each node's head, and nodes lagging more than maxLag
+// blocks behind the best one or slower than maxLatency are skipped until
+// they recover. Failed requests are retried on another node unless they
+// might have side effects.
+//
+// The pool is an http.RoundTripper so the same failover applies to the
+// proxy and to RPC clients created with Dial.
+type UpstreamPool struct {
+	upstreams  []*Upstream
+	interval   time.Duration
+	maxLag     uint64
+	maxLatency time.Duration
+	mx         sync.Mutex
+	transport  http.RoundTripper
+	fin        chan struct{}
+}
+
+// weights may be shorter than urls, missing weights default to 1
+func NewUpstreamPool(
+	urls []*url.URL,
+	weights []int,
+	interval time.Duration,
+	maxLag uint64,
+	maxLatency time.Duration) (*UpstreamPool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("No upstream nodes")
+	}
+
+	upstreams := []*Upstream{}
+	for i, u := range urls {
+		client, err := rpc.DialHTTP(u.String())
+		if err != nil {
+			return nil, err
+		}
+		weight := 1
+		if i < len(weights) && weights[i] > 0 {
+			weight = weights[i]
+		}
+		upstreams = append(upstreams, &Upstream{
+			url:     u,
+			weight:  weight,
+			client:  client,
+			healthy: true,
+		})
+	}
+
+	p := &UpstreamPool{
+		upstreams:  upstreams,
+		interval:   interval,
+		maxLag:     maxLag,
+		maxLatency: maxLatency,
+		transport:  http.DefaultTransport,
+		fin:        make(chan struct{}),
+	}
+	p.check()
+	return p, nil
+}
+
+func (p *UpstreamPool) Run() {
+	log.Println("running upstream health checks")
+	timer := time.NewTicker(p.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			p.check()
+		case <-p.fin:
+			return
+		}
+	}
+}
+
+func (p *UpstreamPool) Stop() {
+	close(p.fin)
+}
+
+func (p *UpstreamPool) check() {
+	type result struct {
+		head    uint64
+		latency time.Duration
+		err     error
+	}
+	results := make([]result, len(p.upstreams))
+
+	var wg sync.WaitGroup
+	for i, upstream := range p.upstreams {
+		wg.Add(1)
+		go func(i int, upstream *Upstream) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+			defer cancel()
+			start := time.Now()
+			var head string
+			err := upstream.client.CallContext(ctx, &head, "eth_blockNumber")
+			if err == nil {
+				results[i].head, err = parseBlockNumber(head)
+			}
+			results[i].latency = time.Since(start)
+			results[i].err = err
+		}(i, upstream)
+	}
+	wg.Wait()
+
+	best := uint64(0)
+	for _, r := range results {
+		if r.err == nil && r.head > best {
+			best = r.head
+		}
+	}
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	for i, upstream := range p.upstreams {
+		r := results[i]
+		healthy := r.err == nil && r.head+p.maxLag >= best && r.latency <= p.maxLatency
+		if healthy != upstream.healthy {
+			log.Printf("Upstream %s healthy: %t (head %d, latency %s, error %v)\n",
+				upstream.url.Host, healthy, r.head, r.latency, r.err)
+		}
+		upstream.healthy = healthy
+		upstream.head = r.head
+		upstream.latency = r.latency
+	}
+}
+
+// Pick a healthy upstream by weight, skipping those already tried. When
+// every untried upstream is unhealthy one of them is used anyway.
+func (p *UpstreamPool) pick(tried map[*Upstream]bool) *Upstream {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	candidates := []*Upstream{}
+	for _, upstream := range p.upstreams {
+		if !tried[upstream] && upstream.healthy {
+			candidates = append(candidates, upstream)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, upstream := range p.upstreams {
+			if !tried[upstream] {
+				candidates = append(candidates, upstream)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, upstream := range candidates {
+		total += upstream.weight
+	}
+	n := rand.Intn(total)
+	for _, upstream := range candidates {
+		n -= upstream.weight
+		if n < 0 {
+			return upstream
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (p *UpstreamPool) markFailed(upstream *Upstream) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if upstream.healthy {
+		log.Printf("Upstream %s failed, skipping until the next health check\n", upstream.url.Host)
+	}
+	upstream.healthy = false
+}
+
+// Requests which may change state are never retried
+func idempotent(body []byte) bool {
+	batch, _, err := bt.ParseBatch(body)
+	if err != nil {
+		return false
+	}
+	for _, jsr := range batch {
+		if strings.HasPrefix(jsr.Method, "eth_send") || strings.HasPrefix(jsr.Method, "personal_") {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *UpstreamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry := idempotent(body)
+
+	tried := map[*Upstream]bool{}
+	var lastErr error
+	for {
+		upstream := p.pick(tried)
+		if upstream == nil {
+			return nil, lastErr
+		}
+		tried[upstream] = true
+
+		upstreamReq := req.Clone(req.Context())
+		upstreamReq.URL.Scheme = upstream.url.Scheme
+		upstreamReq.URL.Host = upstream.url.Host
+		upstreamReq.URL.Path = upstream.url.Path
+		upstreamReq.URL.RawQuery = upstream.url.RawQuery
+		upstreamReq.Host = upstream.url.Host
+		upstreamReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		upstreamReq.ContentLength = int64(len(body))
+
+		res, err := p.transport.RoundTrip(upstreamReq)
+		if err == nil && res.StatusCode < http.StatusInternalServerError && res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+
+		if err == nil {
+			lastErr = fmt.Errorf("Upstream %s returned %s", upstream.url.Host, res.Status)
+		} else {
+			lastErr = err
+		}
+		p.markFailed(upstream)
+
+		if !retry || req.Context().Err() != nil {
+			if err == nil {
+				return res, nil
+			}
+			return nil, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		log.Printf("Retrying after %s\n", lastErr)
+	}
+}
+
+// URL requests to the pool should be sent to
+func (p *UpstreamPool) URL() *url.URL {
+	return &url.URL{Scheme: "http", Host: poolHost}
+}
+
+// Dial returns an RPC client which sends every call through the pool
+func (p *UpstreamPool) Dial() (*rpc.Client, error) {
+	return rpc.DialHTTPWithClient(p.URL().String(), &http.Client{Transport: p})
+}
@@ -0,0 +1,87 @@
+package auction
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts requests and fails every one of them
+func failingNode(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(calls, 1)
+		http.Error(res, "unavailable", http.StatusBadGateway)
+	}))
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	var failures int32
+	broken := failingNode(&failures)
+	defer broken.Close()
+	working := stubNode(t, map[string]interface{}{"eth_blockNumber": "0x10", "eth_chainId": "0x1"})
+	defer working.Close()
+
+	brokenURL, _ := url.Parse(broken.URL)
+	workingURL, _ := url.Parse(working.URL)
+	pool, err := NewUpstreamPool([]*url.URL{brokenURL, workingURL}, []int{100, 1}, time.Second, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The health check has already excluded the broken node
+	proxy := NewProxy(pool)
+	for i := 0; i < 5; i++ {
+		res := httptest.NewRecorder()
+		proxy.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_chainId","id":1}`)))
+		if !strings.Contains(res.Body.String(), `"result":"0x1"`) {
+			t.Fatalf("expected the working node to answer, got %s", res.Body.String())
+		}
+	}
+	if failures != 1 {
+		t.Errorf("expected only the health check to reach the broken node, got %d requests", failures)
+	}
+
+	// Once it looks healthy again a failed read is retried on the other node
+	pool.upstreams[0].healthy = true
+	pool.upstreams[0].weight = 1000000
+	res := httptest.NewRecorder()
+	proxy.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_chainId","id":1}`)))
+	if !strings.Contains(res.Body.String(), `"result":"0x1"`) {
+		t.Errorf("expected the read to be retried, got %s", res.Body.String())
+	}
+
+	// Sends are never retried
+	pool.upstreams[0].healthy = true
+	res = httptest.NewRecorder()
+	proxy.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x"],"id":1}`)))
+	if res.Code != http.StatusBadGateway {
+		t.Errorf("expected the send to fail without retry, got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestUpstreamLag(t *testing.T) {
+	behind := stubNode(t, map[string]interface{}{"eth_blockNumber": "0x10"})
+	defer behind.Close()
+	ahead := stubNode(t, map[string]interface{}{"eth_blockNumber": "0x20"})
+	defer ahead.Close()
+
+	behindURL, _ := url.Parse(behind.URL)
+	aheadURL, _ := url.Parse(ahead.URL)
+	pool, err := NewUpstreamPool([]*url.URL{behindURL, aheadURL}, nil, time.Second, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.upstreams[0].healthy || !pool.upstreams[1].healthy {
+		t.Errorf("expected only the node at the head to be healthy")
+	}
+	for i := 0; i < 10; i++ {
+		if pool.pick(map[*Upstream]bool{}) != pool.upstreams[1] {
+			t.Fatal("picked the lagging node")
+		}
+	}
+}
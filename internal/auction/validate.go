@@ -0,0 +1,193 @@
+package auction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Validator rejects transactions which could never be included before
+// they are stored or forwarded to bidders
+type Validator interface {
+	Validate(tx *types.Transaction) error
+}
+
+// StateReader is the subset of the vanilla node client needed to validate
+// transactions against the chain state
+type StateReader interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+type accountState struct {
+	nonce   uint64
+	balance *big.Int
+	fetched time.Time
+}
+
+// StateValidator checks the signature, chain ID, intrinsic gas, nonce and
+// balance of a transaction. Account state is cached for ttl so a burst of
+// transactions from the same sender costs one pair of state queries.
+type StateValidator struct {
+	state   StateReader
+	ttl     time.Duration
+	mx      sync.Mutex
+	chainID *big.Int
+	signer  types.Signer
+	cache   map[common.Address]accountState
+}
+
+func NewStateValidator(state StateReader, ttl time.Duration) *StateValidator {
+	return &StateValidator{
+		state: state,
+		ttl:   ttl,
+		cache: map[common.Address]accountState{},
+	}
+}
+
+// nodeError is a failure to query the vanilla node. The transaction may
+// well be valid so it isn't recorded as rejected and the client gets a
+// server error to retry on.
+type nodeError struct {
+	err error
+}
+
+func (e *nodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nodeError) Unwrap() error {
+	return e.err
+}
+
+func isNodeError(err error) bool {
+	var nodeErr *nodeError
+	return errors.As(err, &nodeErr)
+}
+
+func rejection(message string, data interface{}) error {
+	return &bt.JsError{
+		Code:    bt.CodeTransactionRejected,
+		Message: message,
+		Data:    data,
+	}
+}
+
+// The chain ID never changes so it is only fetched once
+func (v *StateValidator) chainSigner() (*big.Int, types.Signer, error) {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	if v.chainID == nil {
+		chainID, err := v.state.ChainID(context.Background())
+		if err != nil {
+			return nil, nil, &nodeError{fmt.Errorf("Error: failed to get chain ID %s", err)}
+		}
+		v.chainID = chainID
+		v.signer = types.LatestSignerForChainID(chainID)
+	}
+	return v.chainID, v.signer, nil
+}
+
+func (v *StateValidator) account(address common.Address) (accountState, error) {
+	v.mx.Lock()
+	cached, ok := v.cache[address]
+	v.mx.Unlock()
+	if ok && time.Since(cached.fetched) < v.ttl {
+		return cached, nil
+	}
+
+	ctx := context.Background()
+	nonce, err := v.state.NonceAt(ctx, address, nil)
+	if err != nil {
+		return accountState{}, &nodeError{fmt.Errorf("Error: failed to get nonce %s", err)}
+	}
+	balance, err := v.state.BalanceAt(ctx, address, nil)
+	if err != nil {
+		return accountState{}, &nodeError{fmt.Errorf("Error: failed to get balance %s", err)}
+	}
+
+	state := accountState{
+		nonce:   nonce,
+		balance: balance,
+		fetched: time.Now(),
+	}
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	for addr, entry := range v.cache {
+		if time.Since(entry.fetched) >= v.ttl {
+			delete(v.cache, addr)
+		}
+	}
+	v.cache[address] = state
+	return state, nil
+}
+
+func (v *StateValidator) Validate(tx *types.Transaction) error {
+	chainID, signer, err := v.chainSigner()
+	if err != nil {
+		return err
+	}
+
+	// Legacy transactions without replay protection have no chain ID
+	if tx.Protected() && tx.ChainId().Cmp(chainID) != 0 {
+		return rejection("invalid chain id", map[string]*hexutil.Big{
+			"chainId":         (*hexutil.Big)(tx.ChainId()),
+			"expectedChainId": (*hexutil.Big)(chainID),
+		})
+	}
+
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return rejection("invalid sender", nil)
+	}
+
+	intrinsic, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, true, true)
+	if err != nil {
+		return rejection(err.Error(), nil)
+	}
+	if tx.Gas() < intrinsic {
+		return rejection(core.ErrIntrinsicGas.Error(), map[string]hexutil.Uint64{
+			"gas":          hexutil.Uint64(tx.Gas()),
+			"intrinsicGas": hexutil.Uint64(intrinsic),
+		})
+	}
+
+	account, err := v.account(from)
+	if err != nil {
+		return err
+	}
+
+	if tx.Nonce() < account.nonce {
+		return rejection(core.ErrNonceTooLow.Error(), map[string]hexutil.Uint64{
+			"nonce":         hexutil.Uint64(tx.Nonce()),
+			"expectedNonce": hexutil.Uint64(account.nonce),
+		})
+	}
+
+	// Cost uses the fee cap, which is the most the transaction can spend
+	if account.balance.Cmp(tx.Cost()) < 0 {
+		return rejection(core.ErrInsufficientFunds.Error(), map[string]*hexutil.Big{
+			"balance": (*hexutil.Big)(account.balance),
+			"cost":    (*hexutil.Big)(tx.Cost()),
+		})
+	}
+
+	return nil
+}
+
+type MockValidator struct{}
+
+func (m MockValidator) Validate(tx *types.Transaction) error {
+	return nil
+}
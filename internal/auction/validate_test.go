@@ -0,0 +1,122 @@
+package auction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nukowsk/bukowskis/internal/sender"
+	"github.com/nukowsk/bukowskis/internal/store"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+type stubState struct {
+	nonce   uint64
+	balance *big.Int
+	queries int
+}
+
+func (s *stubState) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(999), nil
+}
+
+func (s *stubState) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	s.queries++
+	return s.nonce, nil
+}
+
+func (s *stubState) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return s.balance, nil
+}
+
+func TestStateValidator(t *testing.T) {
+	state := &stubState{nonce: 5, balance: big.NewInt(1000000)}
+	validator := NewStateValidator(state, time.Minute)
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(chainID int64, nonce uint64, gas uint64, value int64) *types.Transaction {
+		tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(value), gas, big.NewInt(1), nil)
+		signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(chainID)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	cases := []struct {
+		name    string
+		tx      *types.Transaction
+		message string
+	}{
+		{"valid", sign(999, 5, 21000, 1), ""},
+		{"future nonce", sign(999, 9, 21000, 1), ""},
+		{"wrong chain", sign(1, 5, 21000, 1), "invalid chain id"},
+		{"stale nonce", sign(999, 4, 21000, 1), "nonce too low"},
+		{"intrinsic gas", sign(999, 5, 20000, 1), "intrinsic gas too low"},
+		{"insufficient funds", sign(999, 5, 21000, 1000000), "insufficient funds for gas * price + value"},
+	}
+
+	for _, c := range cases {
+		err := validator.Validate(c.tx)
+		if c.message == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", c.name, err)
+			}
+			continue
+		}
+
+		var jsErr *bt.JsError
+		if !errors.As(err, &jsErr) || jsErr.Message != c.message {
+			t.Errorf("%s: expected %q, got %v", c.name, c.message, err)
+		}
+	}
+
+	if state.queries != 1 {
+		t.Errorf("expected account state to be cached, got %d queries", state.queries)
+	}
+}
+
+type unreachableState struct {
+	stubState
+}
+
+func (u *unreachableState) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return 0, errors.New("unreachable")
+}
+
+func TestValidatorNodeFailure(t *testing.T) {
+	local, _ := store.NewLocal()
+	tracker := NewStatusTracker(local, "mock")
+	h := NewHandler(
+		&MockGasGetter{price: big.NewInt(400)},
+		tracker,
+		sender.MockSender{},
+		MockProxy{},
+		HintPolicies{},
+		GasPolicies{},
+		NewStateValidator(&unreachableState{}, time.Minute),
+		NewRateLimiter(RateLimitConfig{}),
+		nil)
+
+	raw, hash := testRawTx(t, 600)
+	var response bt.JsResponse
+	body := serve(h, `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["`+raw+`"],"id":1}`)
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil || response.Error.Code != bt.CodeServerError {
+		t.Errorf("expected a server error, got %s", body)
+	}
+	if status, _ := tracker.Status(hash); status != nil {
+		t.Errorf("expected a node failure not to be recorded, got %+v", status)
+	}
+}
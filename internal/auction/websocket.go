@@ -0,0 +1,162 @@
+package auction
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Client messages larger than this close the connection, matching geth's
+// own websocket limit
+const wsMaxMessageSize = 15 << 20
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Wallets and dapps connect from any origin
+	CheckOrigin: func(req *http.Request) bool { return true },
+}
+
+// WebsocketFrontend serves JSON-RPC over websockets. Every client gets its
+// own connection to the vanilla node so proxied messages, including
+// eth_subscribe and its notifications, are relayed unchanged and keep
+// their ids. Intercepted methods are answered by the handler. Batches are
+// answered as over HTTP so subscriptions must be sent on their own.
+type WebsocketFrontend struct {
+	handler  *Handler
+	upstream string
+	slots    chan struct{}
+}
+
+// maxConnections bounds the number of clients, and so connections to the
+// vanilla node, served at once
+func NewWebsocketFrontend(handler *Handler, upstream string, maxConnections int) *WebsocketFrontend {
+	if maxConnections < 1 {
+		maxConnections = 1
+	}
+	return &WebsocketFrontend{
+		handler:  handler,
+		upstream: upstream,
+		slots:    make(chan struct{}, maxConnections),
+	}
+}
+
+// wsClient is a client connection and its vanilla node connection
+type wsClient struct {
+	handler  *Handler
+	req      *http.Request
+	client   *websocket.Conn
+	upstream *websocket.Conn
+	mx       sync.Mutex
+}
+
+func (w *WebsocketFrontend) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	select {
+	case w.slots <- struct{}{}:
+		defer func() { <-w.slots }()
+	default:
+		log.Println("Websocket refused, too many connections")
+		http.Error(res, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	upstream, _, err := websocket.DefaultDialer.Dial(w.upstream, nil)
+	if err != nil {
+		log.Printf("Vanilla websocket connection failed: %s\n", err)
+		http.Error(res, "vanilla node unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer upstream.Close()
+
+	client, err := wsUpgrader.Upgrade(res, req, nil)
+	if err != nil {
+		log.Printf("Websocket upgrade failed: %s\n", err)
+		return
+	}
+	defer client.Close()
+	client.SetReadLimit(wsMaxMessageSize)
+
+	c := &wsClient{
+		handler:  w.handler,
+		req:      req,
+		client:   client,
+		upstream: upstream,
+	}
+
+	// Closing either side ends both loops
+	go func() {
+		c.relay()
+		client.Close()
+	}()
+
+	for {
+		_, msg, err := client.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+// Relay responses and subscription notifications from the vanilla node
+func (c *wsClient) relay() {
+	for {
+		msgType, msg, err := c.upstream.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.mx.Lock()
+		err = c.client.WriteMessage(msgType, msg)
+		c.mx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *wsClient) write(body interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	err := c.client.WriteJSON(body)
+	if err != nil {
+		log.Printf("Websocket write failed: %s\n", err)
+	}
+}
+
+func (c *wsClient) handle(msg []byte) {
+	h := c.handler
+	batch, isBatch, err := bt.ParseBatch(msg)
+	if err != nil {
+		c.write(bt.NewJsError(bt.CodeParseError, "Parse error"))
+		return
+	}
+
+	if isBatch {
+		if len(batch) == 0 {
+			c.write(bt.NewJsError(bt.CodeInvalidRequest, "Invalid Request, empty batch"))
+			return
+		}
+		responses := h.DispatchBatch(c.req, batch)
+		if len(responses) > 0 {
+			c.write(responses)
+		}
+		return
+	}
+
+	response, proxy := h.Dispatch(c.req, batch[0], len(msg))
+	if !proxy {
+		if response != nil {
+			c.write(response)
+		}
+		return
+	}
+
+	err = c.upstream.WriteMessage(websocket.TextMessage, msg)
+	if err != nil {
+		log.Printf("Vanilla websocket write failed: %s\n", err)
+		c.upstream.Close()
+	}
+}
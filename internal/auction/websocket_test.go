@@ -0,0 +1,92 @@
+package auction
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Answers eth_subscribe with a fixed id followed by a notification
+func stubVanillaWebsocket(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		conn, err := wsUpgrader.Upgrade(res, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var jsr bt.JsRequest
+			if err := conn.ReadJSON(&jsr); err != nil {
+				return
+			}
+			conn.WriteJSON(bt.NewJsResult(jsr.ID, "0xcafe"))
+			conn.WriteMessage(websocket.TextMessage, []byte(
+				`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xcafe","result":{}}}`))
+		}
+	}))
+}
+
+func TestWebsocketFrontend(t *testing.T) {
+	vanilla := stubVanillaWebsocket(t)
+	defer vanilla.Close()
+
+	h := testHandler(t)
+	frontend := httptest.NewServer(NewWebsocketFrontend(h, "ws"+strings.TrimPrefix(vanilla.URL, "http"), 1))
+	defer frontend.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(frontend.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func() map[string]json.RawMessage {
+		var msg map[string]json.RawMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// Subscriptions are relayed with the client's id and the node's
+	// subscription id
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"eth_subscribe","params":["newHeads"],"id":"sub"}`))
+	if msg := read(); string(msg["id"]) != `"sub"` || string(msg["result"]) != `"0xcafe"` {
+		t.Errorf("unexpected subscription response %v", msg)
+	}
+	if msg := read(); string(msg["method"]) != `"eth_subscription"` || !strings.Contains(string(msg["params"]), "0xcafe") {
+		t.Errorf("unexpected notification %v", msg)
+	}
+
+	// Send methods go through the auction
+	raw, hash := testRawTx(t, 600)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["`+raw+`"],"id":7}`))
+	if msg := read(); string(msg["id"]) != "7" || string(msg["result"]) != `"`+hash+`"` {
+		t.Errorf("unexpected send response %v", msg)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"admin_peers","id":8}`))
+	if msg := read(); !strings.Contains(string(msg["error"]), "-32601") {
+		t.Errorf("expected the blocked method to be refused, got %v", msg)
+	}
+
+	// Only one client may hold a vanilla connection at a time
+	_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(frontend.URL, "http"), nil)
+	if err == nil || res == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a second client to be refused, got %v", err)
+	}
+
+	// Oversized messages close the connection
+	conn.WriteMessage(websocket.TextMessage, make([]byte, wsMaxMessageSize+1))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("expected an oversized message to close the connection")
+	}
+}
@@ -0,0 +1,247 @@
+package sender
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+// Returned when the bidder's queue is full and the transaction can't be
+// accepted for delivery
+var ErrServerBusy = &bt.JsError{
+	Code:    bt.CodeServerBusy,
+	Message: "server busy",
+}
+
+// DeliveryListener is told how each queued delivery went
+type DeliveryListener interface {
+	Delivered(tx *types.Transaction)
+	Failed(tx *types.Transaction, err error)
+}
+
+// AsyncSender is implemented by senders which return once a delivery is
+// queued rather than sent
+type AsyncSender interface {
+	SetListener(listener DeliveryListener)
+}
+
+// QueuedSender accepts transactions into a bounded queue and delivers them
+// to a single bidder with a pool of workers. Transactions from the same
+// sender address are always handled by the same worker so their relative
+// order is preserved.
+type QueuedSender struct {
+	sender   Sender
+	lanes    []chan delivery
+	fin      chan struct{}
+	wg       sync.WaitGroup
+	listener DeliveryListener
+	mx       sync.RWMutex
+	stopped  bool
+}
+
+// workers is the number of concurrent deliveries and queueSize the capacity
+// of each worker's queue
+func NewQueuedSender(sender Sender, workers int, queueSize int) *QueuedSender {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	lanes := make([]chan delivery, workers)
+	for i := range lanes {
+		lanes[i] = make(chan delivery, queueSize)
+	}
+
+	return &QueuedSender{
+		sender: sender,
+		lanes:  lanes,
+		fin:    make(chan struct{}),
+	}
+}
+
+// Report the outcome of every delivery to the listener. Must be called
+// before Run.
+func (q *QueuedSender) SetListener(listener DeliveryListener) {
+	q.listener = listener
+}
+
+// Run blocks until Stop is called and all workers have delivered what was
+// queued and returned
+func (q *QueuedSender) Run() {
+	log.Printf("running queued sender with %d workers\n", len(q.lanes))
+	for _, lane := range q.lanes {
+		q.wg.Add(1)
+		go q.work(lane)
+	}
+	q.wg.Wait()
+}
+
+// A queued transaction, a hint about it when hint is set, or a bundle
+// starting with it when bundle is set. The transaction follows its hint
+// when full is set.
+type delivery struct {
+	tx     *types.Transaction
+	hint   *bt.Hint
+	full   bool
+	bundle *bt.SendBundleArgs
+}
+
+func (q *QueuedSender) work(lane chan delivery) {
+	defer q.wg.Done()
+	for {
+		select {
+		case d := <-lane:
+			q.deliver(d)
+		case <-q.fin:
+			q.drain(lane)
+			return
+		}
+	}
+}
+
+// Nothing is queued once stopped so the lane only has to be emptied once
+func (q *QueuedSender) drain(lane chan delivery) {
+	if len(lane) > 0 {
+		log.Printf("Delivering %d queued before stopping\n", len(lane))
+	}
+	for {
+		select {
+		case d := <-lane:
+			q.deliver(d)
+		default:
+			return
+		}
+	}
+}
+
+func (q *QueuedSender) deliver(d delivery) {
+	var err error
+	if d.hint != nil {
+		_, err = q.sender.(HintSender).SendHint(d.tx, *d.hint)
+		if err == nil && d.full {
+			_, err = q.sender.Send(d.tx)
+		}
+	} else if d.bundle != nil {
+		_, err = q.sender.(BundleSender).SendBundle(*d.bundle)
+	} else {
+		_, err = q.sender.Send(d.tx)
+	}
+	if err != nil {
+		log.Printf("Delivery failed: %s\n%s\n", d.tx.Hash().Hex(), err)
+	}
+	if q.listener == nil {
+		return
+	}
+
+	txs := []*types.Transaction{d.tx}
+	if d.bundle != nil {
+		txs, _ = d.bundle.Transactions()
+	}
+	for _, tx := range txs {
+		if err != nil {
+			q.listener.Failed(tx, err)
+		} else {
+			q.listener.Delivered(tx)
+		}
+	}
+}
+
+func (q *QueuedSender) enqueue(d delivery) error {
+	q.mx.RLock()
+	defer q.mx.RUnlock()
+	if q.stopped {
+		return ErrServerBusy
+	}
+
+	lane := q.lanes[q.laneFor(d.tx)]
+	select {
+	case lane <- d:
+		return nil
+	default:
+		return ErrServerBusy
+	}
+}
+
+// Send enqueues the transaction and returns its hash without waiting for
+// delivery. ErrServerBusy is returned if the queue is full.
+func (q *QueuedSender) Send(tx *types.Transaction) (string, error) {
+	if err := q.enqueue(delivery{tx: tx}); err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// SendHint enqueues the hint behind any earlier deliveries from the same
+// sender address
+func (q *QueuedSender) SendHint(tx *types.Transaction, hint bt.Hint) (string, error) {
+	if _, ok := q.sender.(HintSender); !ok {
+		return "", fmt.Errorf("sender does not support hints")
+	}
+	if err := q.enqueue(delivery{tx: tx, hint: &hint}); err != nil {
+		return "", err
+	}
+	return hint.Hash, nil
+}
+
+// SendHintThenTransaction enqueues the hint and the transaction together,
+// the transaction is sent as soon as the hint has been
+func (q *QueuedSender) SendHintThenTransaction(tx *types.Transaction, hint bt.Hint) (string, error) {
+	if _, ok := q.sender.(HintSender); !ok {
+		return "", fmt.Errorf("sender does not support hints")
+	}
+	if err := q.enqueue(delivery{tx: tx, hint: &hint, full: true}); err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// SendBundle enqueues the bundle behind any earlier deliveries from the
+// sender of its first transaction
+func (q *QueuedSender) SendBundle(bundle bt.SendBundleArgs) (string, error) {
+	if _, ok := q.sender.(BundleSender); !ok {
+		return "", fmt.Errorf("sender does not support bundles")
+	}
+	txs, err := bundle.Transactions()
+	if err != nil {
+		return "", err
+	}
+	hash, err := bundle.Hash()
+	if err != nil {
+		return "", err
+	}
+	if err := q.enqueue(delivery{tx: txs[0], bundle: &bundle}); err != nil {
+		return "", err
+	}
+	return hash.Hex(), nil
+}
+
+func (q *QueuedSender) laneFor(tx *types.Transaction) int {
+	if len(q.lanes) == 1 {
+		return 0
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		// Without a sender there is no ordering to preserve
+		return int(tx.Hash()[0]) % len(q.lanes)
+	}
+
+	h := fnv.New32a()
+	h.Write(from.Bytes())
+	return int(h.Sum32() % uint32(len(q.lanes)))
+}
+
+// Stop refuses further deliveries, Run returns once those already queued
+// have been delivered
+func (q *QueuedSender) Stop() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.stopped = true
+	close(q.fin)
+}
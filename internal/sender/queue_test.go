@@ -0,0 +1,199 @@
+package sender
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+type recordingSender struct {
+	mx    sync.Mutex
+	block chan struct{}
+	seen  []uint64
+	hints []string
+}
+
+func (r *recordingSender) Send(tx *types.Transaction) (string, error) {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.seen = append(r.seen, tx.Nonce())
+	return tx.Hash().Hex(), nil
+}
+
+func (r *recordingSender) SendHint(tx *types.Transaction, hint bt.Hint) (string, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.hints = append(r.hints, hint.Hash)
+	return hint.Hash, nil
+}
+
+type countingListener struct {
+	mx        sync.Mutex
+	delivered int
+	failed    int
+}
+
+func (c *countingListener) Delivered(tx *types.Transaction) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.delivered++
+}
+
+func (c *countingListener) Failed(tx *types.Transaction, err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.failed++
+}
+
+func signedTx(t *testing.T, nonce uint64) *types.Transaction {
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(999)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestQueuedSenderOrdering(t *testing.T) {
+	rec := &recordingSender{}
+	q := NewQueuedSender(rec, 4, 16)
+
+	for i := uint64(0); i < 10; i++ {
+		if _, err := q.Send(signedTx(t, i)); err != nil {
+			t.Fatalf("Send failed: %s", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.Run()
+		close(done)
+	}()
+
+	for {
+		rec.mx.Lock()
+		n := len(rec.seen)
+		rec.mx.Unlock()
+		if n == 10 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Stop()
+	<-done
+
+	for i, nonce := range rec.seen {
+		if nonce != uint64(i) {
+			t.Fatalf("Out of order delivery: %v", rec.seen)
+		}
+	}
+}
+
+func TestQueuedSenderBusy(t *testing.T) {
+	rec := &recordingSender{block: make(chan struct{})}
+	q := NewQueuedSender(rec, 1, 1)
+
+	if _, err := q.Send(signedTx(t, 0)); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		q.Run()
+		close(done)
+	}()
+
+	// The worker takes the first and blocks delivering it, making room
+	// for exactly one more
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := q.Send(signedTx(t, 1)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the worker to take the first transaction")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err := q.Send(signedTx(t, 2))
+	if !errors.Is(err, ErrServerBusy) {
+		t.Fatalf("Expected server busy, got %v", err)
+	}
+
+	close(rec.block)
+	q.Stop()
+	<-done
+}
+
+func TestQueuedSenderStopDrains(t *testing.T) {
+	rec := &recordingSender{}
+	q := NewQueuedSender(rec, 2, 16)
+
+	for i := uint64(0); i < 10; i++ {
+		if _, err := q.Send(signedTx(t, i)); err != nil {
+			t.Fatalf("Send failed: %s", err)
+		}
+	}
+
+	// Stopped before the workers start, everything queued is still sent
+	q.Stop()
+	q.Run()
+	if len(rec.seen) != 10 {
+		t.Errorf("Expected 10 deliveries, got %d", len(rec.seen))
+	}
+	if _, err := q.Send(signedTx(t, 10)); !errors.Is(err, ErrServerBusy) {
+		t.Errorf("Expected a stopped sender to refuse, got %v", err)
+	}
+}
+
+func TestQueuedSenderHintThenTransaction(t *testing.T) {
+	rec := &recordingSender{}
+	listener := &countingListener{}
+	q := NewQueuedSender(rec, 1, 1)
+	q.SetListener(listener)
+
+	tx := signedTx(t, 0)
+	if _, err := q.SendHintThenTransaction(tx, bt.Hint{Hash: tx.Hash().Hex()}); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	other := signedTx(t, 1)
+	if _, err := q.SendHintThenTransaction(other, bt.Hint{Hash: other.Hash().Hex()}); !errors.Is(err, ErrServerBusy) {
+		t.Fatalf("Expected server busy, got %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.Run()
+		close(done)
+	}()
+	for {
+		listener.mx.Lock()
+		n := listener.delivered + listener.failed
+		listener.mx.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Stop()
+	<-done
+
+	if len(rec.hints) != 1 || len(rec.seen) != 1 || listener.delivered != 1 {
+		t.Errorf("expected the hint and transaction delivered as one, got %d hints, %d transactions and %d deliveries",
+			len(rec.hints), len(rec.seen), listener.delivered)
+	}
+}
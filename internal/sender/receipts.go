@@ -0,0 +1,197 @@
+package sender
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the latency histogram buckets. Slower deliveries are
+// counted in a final +Inf bucket.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Receipt describes a single delivery to a bidder
+type Receipt struct {
+	Destination string        `json:"destination"`
+	Transaction string        `json:"transaction"`
+	Method      string        `json:"method"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	StatusCode  int           `json:"statusCode"`
+	Result      string        `json:"result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+func (r Receipt) Failed() bool {
+	return r.Error != "" || r.StatusCode != http.StatusOK
+}
+
+type Recorder interface {
+	Record(Receipt)
+}
+
+type LatencyBucket struct {
+	UpperBound string `json:"le"`
+	Count      uint64 `json:"count"`
+}
+
+// Aggregated deliveries to a single bidder
+type BidderStats struct {
+	Destination   string          `json:"destination"`
+	Deliveries    uint64          `json:"deliveries"`
+	Errors        uint64          `json:"errors"`
+	ErrorRate     float64         `json:"errorRate"`
+	MeanLatency   time.Duration   `json:"meanLatency"`
+	Histogram     []LatencyBucket `json:"histogram"`
+	LastDelivery  time.Time       `json:"lastDelivery"`
+	totalDuration time.Duration
+	counts        []uint64
+}
+
+// Receipts keeps latency and error statistics per bidder along with the
+// most recent receipts
+type Receipts struct {
+	token   string
+	mx      sync.Mutex
+	stats   map[string]*BidderStats
+	recent  []Receipt
+	next    int
+	maxSize int
+}
+
+// maxRecent bounds the number of individual receipts retained and token
+// authenticates requests for them
+func NewReceipts(maxRecent int, token string) *Receipts {
+	return &Receipts{
+		token:   token,
+		stats:   map[string]*BidderStats{},
+		recent:  []Receipt{},
+		maxSize: maxRecent,
+	}
+}
+
+func (r *Receipts) Record(receipt Receipt) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if len(r.recent) < r.maxSize {
+		r.recent = append(r.recent, receipt)
+	} else if r.maxSize > 0 {
+		r.recent[r.next] = receipt
+		r.next = (r.next + 1) % r.maxSize
+	}
+
+	stats, ok := r.stats[receipt.Destination]
+	if !ok {
+		stats = &BidderStats{
+			Destination: receipt.Destination,
+			counts:      make([]uint64, len(latencyBuckets)+1),
+		}
+		r.stats[receipt.Destination] = stats
+	}
+
+	stats.Deliveries++
+	if receipt.Failed() {
+		stats.Errors++
+	}
+	stats.totalDuration += receipt.Duration
+	stats.LastDelivery = receipt.Start
+
+	i := sort.Search(len(latencyBuckets), func(i int) bool {
+		return receipt.Duration <= latencyBuckets[i]
+	})
+	stats.counts[i]++
+}
+
+// Stats returns a snapshot of every bidder's statistics
+func (r *Receipts) Stats() []BidderStats {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	all := []BidderStats{}
+	for _, stats := range r.stats {
+		snapshot := *stats
+		snapshot.ErrorRate = float64(stats.Errors) / float64(stats.Deliveries)
+		snapshot.MeanLatency = stats.totalDuration / time.Duration(stats.Deliveries)
+		snapshot.Histogram = make([]LatencyBucket, len(stats.counts))
+		for i, count := range stats.counts {
+			bound := "+Inf"
+			if i < len(latencyBuckets) {
+				bound = latencyBuckets[i].String()
+			}
+			snapshot.Histogram[i] = LatencyBucket{UpperBound: bound, Count: count}
+		}
+		all = append(all, snapshot)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Destination < all[j].Destination
+	})
+	return all
+}
+
+// Recent returns the retained receipts, oldest first
+func (r *Receipts) Recent() []Receipt {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	recent := make([]Receipt, 0, len(r.recent))
+	recent = append(recent, r.recent[r.next:]...)
+	recent = append(recent, r.recent[:r.next]...)
+	return recent
+}
+
+type receiptsResponse struct {
+	Bidders []BidderStats `json:"bidders"`
+	Recent  []Receipt     `json:"recent"`
+}
+
+// The token is sent as a bearer token or the token query parameter. An
+// empty token authorizes nothing.
+func authorized(req *http.Request, token string) bool {
+	given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = req.URL.Query().Get("token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// ServeHTTP exposes the statistics as JSON. The optional destination query
+// parameter limits the recent receipts to a single bidder.
+func (r *Receipts) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if !authorized(req, r.token) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	destination := req.URL.Query().Get("destination")
+	recent := []Receipt{}
+	for _, receipt := range r.Recent() {
+		if destination == "" || receipt.Destination == destination {
+			recent = append(recent, receipt)
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(res).Encode(receiptsResponse{
+		Bidders: r.Stats(),
+		Recent:  recent,
+	})
+	if err != nil {
+		log.Printf("Encoding error %s", err)
+	}
+}
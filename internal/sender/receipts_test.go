@@ -0,0 +1,120 @@
+package sender
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReceipts(t *testing.T) {
+	r := NewReceipts(3, "secret")
+	durations := []time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		30 * time.Millisecond,
+		10 * time.Second,
+	}
+	for i, duration := range durations {
+		receipt := Receipt{Destination: "a", Transaction: string(rune('0' + i)), Duration: duration, StatusCode: http.StatusOK}
+		if i == 3 {
+			receipt.Error = "timeout"
+		}
+		r.Record(receipt)
+	}
+	r.Record(Receipt{Destination: "b", Duration: time.Millisecond, StatusCode: http.StatusBadGateway})
+
+	stats := r.Stats()
+	if len(stats) != 2 || stats[0].Destination != "a" || stats[1].Destination != "b" {
+		t.Fatalf("expected stats for a and b, got %+v", stats)
+	}
+	a := stats[0]
+	if a.Deliveries != 4 || a.Errors != 1 || a.ErrorRate != 0.25 {
+		t.Errorf("expected 1 of 4 deliveries to fail, got %d of %d (%f)", a.Errors, a.Deliveries, a.ErrorRate)
+	}
+	if a.MeanLatency != (5*time.Millisecond+10*time.Millisecond+30*time.Millisecond+10*time.Second)/4 {
+		t.Errorf("unexpected mean latency %s", a.MeanLatency)
+	}
+	expected := map[string]uint64{"10ms": 2, "50ms": 1, "+Inf": 1}
+	for _, bucket := range a.Histogram {
+		if bucket.Count != expected[bucket.UpperBound] {
+			t.Errorf("expected %d in the %s bucket, got %d", expected[bucket.UpperBound], bucket.UpperBound, bucket.Count)
+		}
+	}
+	if stats[1].ErrorRate != 1 {
+		t.Errorf("expected a non-200 status to be an error, got %f", stats[1].ErrorRate)
+	}
+
+	// Only the three most recent are kept, oldest first
+	recent := r.Recent()
+	if len(recent) != 3 || recent[0].Transaction != "2" || recent[1].Transaction != "3" || recent[2].Destination != "b" {
+		t.Errorf("unexpected recent receipts %+v", recent)
+	}
+}
+
+func TestReceiptsAuth(t *testing.T) {
+	r := NewReceipts(10, "secret")
+	r.Record(Receipt{Destination: "a", StatusCode: http.StatusOK})
+
+	for _, url := range []string{"/", "/?token=wrong"} {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest("GET", url, nil))
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected unauthorized, got %d", url, res.Code)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?destination=b", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(res, req)
+	var response receiptsResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Bidders) != 1 || len(response.Recent) != 0 {
+		t.Errorf("expected the stats without a's receipts, got %+v", response)
+	}
+
+	res = httptest.NewRecorder()
+	NewReceipts(10, "").ServeHTTP(res, httptest.NewRequest("GET", "/?token=", nil))
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("expected an empty token to authorize nothing, got %d", res.Code)
+	}
+}
+
+// Acknowledged deliveries are reported to the recorder and the listener
+func TestStreamSenderReceipts(t *testing.T) {
+	r := NewReceipts(10, "secret")
+	listener := &countingListener{}
+	s := NewStreamSender("secret", 1)
+	s.SetRecorder(r)
+	s.SetListener(listener)
+
+	tx := signedTx(t, 0)
+	if _, err := s.Send(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Send(signedTx(t, 1)); err != ErrServerBusy {
+		t.Fatalf("expected ErrServerBusy, got %v", err)
+	}
+	if listener.delivered != 0 {
+		t.Errorf("expected nothing delivered before the ack, got %d", listener.delivered)
+	}
+	s.ack(1)
+	if listener.delivered != 1 || listener.failed != 0 {
+		t.Errorf("expected the ack to be reported as a delivery, got %d", listener.delivered)
+	}
+
+	recent := r.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("expected a refused and an acknowledged receipt, got %+v", recent)
+	}
+	if !recent[0].Failed() || recent[0].Error != ErrServerBusy.Error() {
+		t.Errorf("expected the refusal first, got %+v", recent[0])
+	}
+	if recent[1].Failed() || recent[1].Transaction != tx.Hash().Hex() || recent[1].Method != "transaction" {
+		t.Errorf("expected the acknowledged transaction, got %+v", recent[1])
+	}
+}
@@ -2,20 +2,34 @@ package sender
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	bt "github.com/nukowsk/bukowskis/internal/types"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
-// TODO: BundleSend
+// Deliveries which take longer are abandoned so a stalled bidder can't hold
+// up a queue worker indefinitely
+const httpTimeout = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: httpTimeout}
+
 func HTTPSend(url string, tx *types.Transaction) (string, error) {
 	request, err := bt.NewSendRawRequest(tx)
 	if err != nil {
 		panic(err)
 	}
+	res, _, err := httpPost(url, request)
+	return res, err
+}
+
+// Returns the JSON-RPC result and the HTTP status code, which is 0 if no
+// response was received
+func httpPost(url string, request bt.JsRequest) (string, int, error) {
 	payloadBuf := new(bytes.Buffer)
 	json.NewEncoder(payloadBuf).Encode(request)
 
@@ -25,50 +39,137 @@ func HTTPSend(url string, tx *types.Transaction) (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		panic(err)
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
 	jsonResp := bt.JsResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&jsonResp)
 	if err != nil {
-		return "", fmt.Errorf("Failed to decode response body: %s", err)
+		return "", resp.StatusCode, fmt.Errorf("Failed to decode response body: %s", err)
 	}
 
 	if jsonResp.Error != nil {
-		return "", fmt.Errorf("failed %s", jsonResp.Error.Message)
+		return "", resp.StatusCode, fmt.Errorf("failed %s", jsonResp.Error.Message)
 	}
 
 	if jsonResp.Result == nil {
-		return "", nil
+		return "", resp.StatusCode, nil
 	}
 
 	res, ok := jsonResp.Result.(string)
 	if !ok {
-		return "", nil
+		return "", resp.StatusCode, nil
 	}
-	return res, nil
+	return res, resp.StatusCode, nil
 }
 
 type Sender interface {
 	Send(tx *types.Transaction) (string, error)
 }
 
+// HintSender is implemented by senders which can deliver a redacted hint
+// in place of the transaction. The transaction itself is never forwarded
+// and is only used to order the hint relative to other deliveries.
+type HintSender interface {
+	SendHint(tx *types.Transaction, hint bt.Hint) (string, error)
+}
+
+// HintThenSender is implemented by senders which queue deliveries. The hint
+// and the transaction are queued as one unit so the transaction can't be
+// refused once its hint has been accepted.
+type HintThenSender interface {
+	SendHintThenTransaction(tx *types.Transaction, hint bt.Hint) (string, error)
+}
+
+// BundleSender is implemented by senders which can deliver searcher
+// bundles to the winning bidder
+type BundleSender interface {
+	SendBundle(bundle bt.SendBundleArgs) (string, error)
+}
+
 type HTTPSender struct {
-	url string
+	url       string
+	publicKey *ecdsa.PublicKey
+	recorder  Recorder
 }
 
 func NewHTTPSender(url string) *HTTPSender {
 	return &HTTPSender{
-		url,
+		url: url,
+	}
+}
+
+// Transactions are encrypted to the bidder's public key so only the bidder
+// can read them
+func NewEncryptedHTTPSender(url string, publicKey *ecdsa.PublicKey) *HTTPSender {
+	return &HTTPSender{
+		url:       url,
+		publicKey: publicKey,
 	}
 }
 
+// Report a receipt for every delivery to the recorder
+func (h *HTTPSender) SetRecorder(recorder Recorder) {
+	h.recorder = recorder
+}
+
 func (h HTTPSender) Send(tx *types.Transaction) (string, error) {
-	return HTTPSend(h.url, tx)
+	var request bt.JsRequest
+	var err error
+	if h.publicKey != nil {
+		request, err = bt.NewSendEncryptedRequest(tx, h.publicKey)
+	} else {
+		request, err = bt.NewSendRawRequest(tx)
+	}
+	if err != nil {
+		return "", err
+	}
+	return h.post(tx.Hash().Hex(), request)
+}
+
+func (h HTTPSender) SendHint(tx *types.Transaction, hint bt.Hint) (string, error) {
+	return h.post(hint.Hash, bt.NewSendHintRequest(hint))
+}
+
+func (h HTTPSender) SendBundle(bundle bt.SendBundleArgs) (string, error) {
+	hash, err := bundle.Hash()
+	if err != nil {
+		return "", err
+	}
+
+	request := bt.NewSendBundleRequest(bundle)
+	if h.publicKey != nil {
+		request, err = bt.NewSendEncryptedBundleRequest(bundle, h.publicKey)
+		if err != nil {
+			return "", err
+		}
+	}
+	return h.post(hash.Hex(), request)
+}
+
+// id identifies the transaction or bundle in the receipt
+func (h HTTPSender) post(id string, request bt.JsRequest) (string, error) {
+	start := time.Now()
+	result, status, err := httpPost(h.url, request)
+	if h.recorder != nil {
+		receipt := Receipt{
+			Destination: h.url,
+			Transaction: id,
+			Method:      request.Method,
+			Start:       start,
+			Duration:    time.Since(start),
+			StatusCode:  status,
+			Result:      result,
+		}
+		if err != nil {
+			receipt.Error = err.Error()
+		}
+		h.recorder.Record(receipt)
+	}
+	return result, err
 }
 
 type MockSender struct{}
@@ -76,3 +177,15 @@ type MockSender struct{}
 func (m MockSender) Send(tx *types.Transaction) (string, error) {
 	return tx.Hash().Hex(), nil
 }
+
+func (m MockSender) SendHint(tx *types.Transaction, hint bt.Hint) (string, error) {
+	return hint.Hash, nil
+}
+
+func (m MockSender) SendBundle(bundle bt.SendBundleArgs) (string, error) {
+	hash, err := bundle.Hash()
+	if err != nil {
+		return "", err
+	}
+	return hash.Hex(), nil
+}
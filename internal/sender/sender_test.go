@@ -0,0 +1,57 @@
+package sender
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func TestEncryptedBundle(t *testing.T) {
+	var received bt.JsRequest
+	bidder := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&received); err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(res).Encode(bt.NewJsResult(received.ID, "ok"))
+	}))
+	defer bidder.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := bt.HexEncodeTransaction(signedTx(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := bt.SendBundleArgs{Txs: []string{raw}, BlockNumber: 101}
+
+	s := NewEncryptedHTTPSender(bidder.URL, &key.PublicKey)
+	if _, err := s.SendBundle(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if received.Method != "bukowskis_sendEncryptedBundle" {
+		t.Fatalf("expected an encrypted bundle, got %s", received.Method)
+	}
+
+	data, _ := json.Marshal(received.Params[0])
+	if strings.Contains(string(data), strings.TrimPrefix(raw, "0x")) {
+		t.Error("expected the transaction not to be sent in plaintext")
+	}
+	var sent bt.SendBundleArgs
+	if err := json.Unmarshal(data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := bt.DecryptBundle(sent, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decrypted.Txs) != 1 || decrypted.Txs[0] != raw || decrypted.BlockNumber != 101 {
+		t.Errorf("expected the original bundle, got %+v", decrypted)
+	}
+}
@@ -0,0 +1,346 @@
+package sender
+
+import (
+	"crypto/ecdsa"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/websocket"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+const streamWriteTimeout = 10 * time.Second
+
+// Sent to the bidder for every transaction it has won. Exactly one of
+// Transaction, Hint or Bundle is set. Sequence numbers restart whenever the
+// epoch changes. When Encrypted is set the transaction, or each of the
+// bundle's transactions, is encrypted to the bidder's public key.
+type StreamMessage struct {
+	Epoch       string             `json:"epoch"`
+	Seq         uint64             `json:"seq"`
+	Hash        string             `json:"hash"`
+	Transaction string             `json:"tx,omitempty"`
+	Hint        *bt.Hint           `json:"hint,omitempty"`
+	Bundle      *bt.SendBundleArgs `json:"bundle,omitempty"`
+	Encrypted   bool               `json:"encrypted,omitempty"`
+	queued      time.Time
+	// Reported delivered to the listener once acknowledged
+	txs []*types.Transaction
+}
+
+func (m StreamMessage) kind() string {
+	if m.Hint != nil {
+		return "hint"
+	} else if m.Bundle != nil {
+		return "bundle"
+	}
+	return "transaction"
+}
+
+// Sent by the bidder once it has processed every message up to Ack
+type StreamAck struct {
+	Ack uint64 `json:"ack"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// StreamSender delivers transactions to a bidder over a websocket instead
+// of issuing an HTTP request per transaction. Messages are numbered and
+// kept until the bidder acknowledges them so a reconnecting bidder can
+// resume from the last sequence number it has seen. Messages are only kept
+// in memory, the epoch identifies this instance so bidders can tell when
+// the auction has restarted.
+type StreamSender struct {
+	token      string
+	maxPending int
+	epoch      string
+	publicKey  *ecdsa.PublicKey
+	recorder   Recorder
+	listener   DeliveryListener
+
+	mx      sync.Mutex
+	next    uint64
+	pending []StreamMessage
+	notify  chan struct{}
+}
+
+// token authenticates the bidder and maxPending bounds the number of
+// unacknowledged messages
+func NewStreamSender(token string, maxPending int) *StreamSender {
+	return &StreamSender{
+		token:      token,
+		maxPending: maxPending,
+		epoch:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		next:       1,
+		pending:    []StreamMessage{},
+		notify:     make(chan struct{}),
+	}
+}
+
+// Transactions are encrypted to the bidder's public key so only the bidder
+// can read them
+func NewEncryptedStreamSender(token string, maxPending int, publicKey *ecdsa.PublicKey) *StreamSender {
+	s := NewStreamSender(token, maxPending)
+	s.publicKey = publicKey
+	return s
+}
+
+// Report a receipt for every acknowledged or refused message to the
+// recorder
+func (s *StreamSender) SetRecorder(recorder Recorder) {
+	s.recorder = recorder
+}
+
+// Report every acknowledged delivery to the listener. Must be called
+// before the stream is served.
+func (s *StreamSender) SetListener(listener DeliveryListener) {
+	s.listener = listener
+}
+
+// Send queues the transaction for the connected bidder. ErrServerBusy is
+// returned when too many messages are unacknowledged.
+func (s *StreamSender) Send(tx *types.Transaction) (string, error) {
+	msg, err := s.transactionMessage(tx)
+	if err != nil {
+		return "", err
+	}
+	err = s.push(msg)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+func (s *StreamSender) transactionMessage(tx *types.Transaction) (StreamMessage, error) {
+	var encoded string
+	var err error
+	if s.publicKey != nil {
+		encoded, err = bt.EncryptTransaction(tx, s.publicKey)
+	} else {
+		encoded, err = bt.HexEncodeTransaction(tx)
+	}
+	if err != nil {
+		return StreamMessage{}, err
+	}
+	return StreamMessage{
+		Hash:        tx.Hash().Hex(),
+		Transaction: encoded,
+		Encrypted:   s.publicKey != nil,
+		txs:         []*types.Transaction{tx},
+	}, nil
+}
+
+func (s *StreamSender) SendHint(tx *types.Transaction, hint bt.Hint) (string, error) {
+	err := s.push(StreamMessage{
+		Hash: hint.Hash,
+		Hint: &hint,
+		txs:  []*types.Transaction{tx},
+	})
+	if err != nil {
+		return "", err
+	}
+	return hint.Hash, nil
+}
+
+// SendHintThenTransaction queues the hint and the transaction together,
+// neither is queued if there isn't room for both
+func (s *StreamSender) SendHintThenTransaction(tx *types.Transaction, hint bt.Hint) (string, error) {
+	msg, err := s.transactionMessage(tx)
+	if err != nil {
+		return "", err
+	}
+	err = s.push(StreamMessage{Hash: hint.Hash, Hint: &hint}, msg)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+func (s *StreamSender) SendBundle(bundle bt.SendBundleArgs) (string, error) {
+	hash, err := bundle.Hash()
+	if err != nil {
+		return "", err
+	}
+	txs, err := bundle.Transactions()
+	if err != nil {
+		return "", err
+	}
+
+	if s.publicKey != nil {
+		bundle, err = bt.EncryptBundle(bundle, s.publicKey)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = s.push(StreamMessage{
+		Hash:      hash.Hex(),
+		Bundle:    &bundle,
+		Encrypted: s.publicKey != nil,
+		txs:       txs,
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash.Hex(), nil
+}
+
+// Messages are queued together or not at all
+func (s *StreamSender) push(msgs ...StreamMessage) error {
+	s.mx.Lock()
+	if len(s.pending)+len(msgs) > s.maxPending {
+		s.mx.Unlock()
+		for _, msg := range msgs {
+			s.record(msg, ErrServerBusy)
+		}
+		return ErrServerBusy
+	}
+
+	for _, msg := range msgs {
+		msg.Epoch = s.epoch
+		msg.Seq = s.next
+		msg.queued = time.Now()
+		s.pending = append(s.pending, msg)
+		s.next++
+	}
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mx.Unlock()
+
+	return nil
+}
+
+// Drop every message up to and including seq
+func (s *StreamSender) ack(seq uint64) {
+	s.mx.Lock()
+	i := 0
+	for i < len(s.pending) && s.pending[i].Seq <= seq {
+		i++
+	}
+	acked := s.pending[:i]
+	s.pending = s.pending[i:]
+	s.mx.Unlock()
+
+	for _, msg := range acked {
+		s.record(msg, nil)
+		if s.listener == nil {
+			continue
+		}
+		for _, tx := range msg.txs {
+			s.listener.Delivered(tx)
+		}
+	}
+}
+
+// The duration of a delivery is the time until the bidder acknowledged it
+func (s *StreamSender) record(msg StreamMessage, err error) {
+	if s.recorder == nil {
+		return
+	}
+	receipt := Receipt{
+		Destination: "stream",
+		Transaction: msg.Hash,
+		Method:      msg.kind(),
+		Start:       msg.queued,
+		Duration:    time.Since(msg.queued),
+		StatusCode:  http.StatusOK,
+	}
+	if err != nil {
+		receipt.Start = time.Now()
+		receipt.Duration = 0
+		receipt.StatusCode = 0
+		receipt.Error = err.Error()
+	}
+	s.recorder.Record(receipt)
+}
+
+// Returns pending messages starting from seq and a channel which is closed
+// when more arrive
+func (s *StreamSender) since(seq uint64) ([]StreamMessage, chan struct{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	msgs := []StreamMessage{}
+	for _, msg := range s.pending {
+		if msg.Seq >= seq {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, s.notify
+}
+
+// ServeHTTP upgrades an authenticated bidder to a websocket and streams
+// transactions to it. The optional "from" query parameter resumes the
+// stream at that sequence number, acknowledging everything before it. It
+// is only honoured when the "epoch" parameter matches this instance,
+// otherwise the stream starts again from the first pending message.
+func (s *StreamSender) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if !authorized(req, s.token) {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor := uint64(0)
+	if from := req.URL.Query().Get("from"); from != "" {
+		seq, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			http.Error(res, "invalid from", http.StatusBadRequest)
+			return
+		}
+		if req.URL.Query().Get("epoch") == s.epoch {
+			cursor = seq
+			if seq > 0 {
+				s.ack(seq - 1)
+			}
+		} else if seq > 0 {
+			log.Printf("Bidder resumed from %d of another epoch, restarting the stream\n", seq)
+		}
+	}
+
+	conn, err := upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		log.Printf("Stream upgrade failed: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Bidder connected to stream from %d\n", cursor)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var ack StreamAck
+			if err := conn.ReadJSON(&ack); err != nil {
+				log.Printf("Stream closed: %s\n", err)
+				return
+			}
+			s.ack(ack.Ack)
+		}
+	}()
+
+	for {
+		msgs, notify := s.since(cursor)
+		for _, msg := range msgs {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				log.Printf("Stream write failed: %s\n", err)
+				return
+			}
+			cursor = msg.Seq + 1
+		}
+
+		select {
+		case <-notify:
+		case <-closed:
+			return
+		}
+	}
+}
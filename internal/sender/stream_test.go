@@ -0,0 +1,175 @@
+package sender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/websocket"
+	bt "github.com/nukowsk/bukowskis/internal/types"
+)
+
+func dialStream(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readStream(t *testing.T, conn *websocket.Conn) StreamMessage {
+	var msg StreamMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestStreamSenderAuth(t *testing.T) {
+	server := httptest.NewServer(NewStreamSender("secret", 10))
+	defer server.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?token=" + token
+		_, res, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected token %q to be unauthorized, got %v", token, err)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("expected the bearer token to be accepted: %s", err)
+	}
+	conn.Close()
+}
+
+func TestStreamSenderResume(t *testing.T) {
+	s := NewStreamSender("secret", 10)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	for i := uint64(0); i < 3; i++ {
+		if _, err := s.Send(signedTx(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := dialStream(t, server, "token=secret")
+	first := readStream(t, conn)
+	if first.Seq != 1 || first.Epoch == "" {
+		t.Fatalf("expected the first message of the epoch, got %+v", first)
+	}
+	conn.Close()
+
+	// Resuming acknowledges everything before the cursor
+	conn = dialStream(t, server, "token=secret&epoch="+first.Epoch+"&from=3")
+	if msg := readStream(t, conn); msg.Seq != 3 {
+		t.Errorf("expected to resume at 3, got %d", msg.Seq)
+	}
+	conn.Close()
+	if msgs, _ := s.since(0); len(msgs) != 1 {
+		t.Errorf("expected one unacknowledged message, got %d", len(msgs))
+	}
+
+	// A restarted auction has a new epoch and numbers from 1 again
+	restarted := NewStreamSender("secret", 10)
+	server = httptest.NewServer(restarted)
+	defer server.Close()
+	if _, err := restarted.Send(signedTx(t, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn = dialStream(t, server, "token=secret&epoch="+first.Epoch+"&from=4")
+	defer conn.Close()
+	if msg := readStream(t, conn); msg.Seq != 1 || msg.Epoch == first.Epoch {
+		t.Errorf("expected the stream to restart in a new epoch, got %+v", msg)
+	}
+}
+
+func TestStreamSenderOverflow(t *testing.T) {
+	s := NewStreamSender("secret", 2)
+	for i := uint64(0); i < 2; i++ {
+		if _, err := s.Send(signedTx(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Send(signedTx(t, 2)); err != ErrServerBusy {
+		t.Fatalf("expected ErrServerBusy, got %v", err)
+	}
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+	conn := dialStream(t, server, "token=secret")
+	defer conn.Close()
+	msg := readStream(t, conn)
+	if err := conn.WriteJSON(StreamAck{Ack: msg.Seq}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ack is processed asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.Send(signedTx(t, 2))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected room after an ack, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if msgs, _ := s.since(0); len(msgs) != 2 || msgs[1].Seq != 3 {
+		t.Errorf("expected messages 2 and 3 pending, got %+v", msgs)
+	}
+}
+
+func TestStreamSenderEncrypted(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewEncryptedStreamSender("secret", 10, &key.PublicKey)
+	tx := signedTx(t, 0)
+	if _, err := s.Send(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, _ := s.since(0)
+	if len(msgs) != 1 || !msgs[0].Encrypted {
+		t.Fatalf("expected an encrypted message, got %+v", msgs)
+	}
+	decrypted, err := bt.DecryptTransaction(msgs[0].Transaction, key)
+	if err != nil || decrypted.Hash() != tx.Hash() {
+		t.Errorf("expected the transaction encrypted to the bidder, got %v", err)
+	}
+}
+
+func TestStreamSenderHintThenTransaction(t *testing.T) {
+	s := NewStreamSender("secret", 2)
+	if _, err := s.Send(signedTx(t, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := signedTx(t, 1)
+	if _, err := s.SendHintThenTransaction(tx, bt.Hint{Hash: tx.Hash().Hex()}); err != ErrServerBusy {
+		t.Fatalf("expected ErrServerBusy, got %v", err)
+	}
+	if msgs, _ := s.since(0); len(msgs) != 1 {
+		t.Fatalf("expected the hint not to be queued without its transaction, got %+v", msgs)
+	}
+
+	s.ack(1)
+	if _, err := s.SendHintThenTransaction(tx, bt.Hint{Hash: tx.Hash().Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.since(0); len(msgs) != 2 || msgs[0].Hint == nil || msgs[1].Transaction == "" {
+		t.Errorf("expected the hint followed by the transaction, got %+v", msgs)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -11,38 +12,74 @@ import (
 	"github.com/mitchellh/hashstructure/v2"
 )
 
-func storeID(tx *types.Transaction) (string, error) {
-	objectHash, err := hashstructure.Hash(tx.Hash().Hex(), hashstructure.FormatV2, nil)
+func storeID(txHash string) (string, error) {
+	objectHash, err := hashstructure.Hash(txHash, hashstructure.FormatV2, nil)
 	if err != nil {
 		return "", fmt.Errorf("Failed to hash raw transaction: %s\n", err)
 	}
 	return strconv.FormatUint(objectHash, 10), nil
 }
 
-// Hash and ID are confusing and should be given more distinctive names
+// Lifecycle of a transaction in the auction
+const (
+	StatusReceived  = "received"
+	StatusRejected  = "rejected"
+	StatusQueued    = "queued"
+	StatusDelivered = "delivered"
+	StatusIncluded  = "included"
+	StatusDropped   = "dropped"
+)
+
+// Hash and ID are confusing and should be given more distinctive names.
+// AuctionBlock and Bidder are set on delivery, IncludedBlock on inclusion
+// and Reason when the transaction is rejected or dropped. RebateRecipient
+// is empty unless the user attached a rebate.
 type LogEntry struct {
-	Hash        string
-	Transaction string
-	Auction     string
-	Timestamp   time.Time
+	Hash            string
+	Transaction     string
+	Status          string
+	Reason          string
+	AuctionBlock    uint64
+	Bidder          string
+	IncludedBlock   uint64
+	RebateRecipient string
+	RebatePercent   uint64
+	Timestamp       time.Time
+	Updated         time.Time
 }
 
 func NewLogEntry(tx *types.Transaction) (LogEntry, error) {
-	hash, err := storeID(tx)
+	hash, err := storeID(tx.Hash().Hex())
 	if err != nil {
 		return LogEntry{}, err
 	}
 
+	now := time.Now() // XXX: Probably want to pass this in
 	return LogEntry{
 		Hash:        hash,
 		Transaction: tx.Hash().Hex(),
-		Auction:     "open",
-		Timestamp:   time.Now(), // XXX: Probably want to pass this in
+		Status:      StatusReceived,
+		Timestamp:   now,
+		Updated:     now,
 	}, nil
 }
 
+// A gas reading in wei, prices are decimal strings
+type GasEntry struct {
+	Source      string
+	Tiers       map[string]string
+	BaseFee     string
+	PriorityFee string
+	Timestamp   time.Time
+}
+
+// Save fails if the transaction is already stored, Update overwrites it and
+// Get returns nil for unknown transaction hashes
 type Store interface {
 	Save(*LogEntry) error
+	Update(*LogEntry) error
+	Get(string) (*LogEntry, error)
+	SaveGas(*GasEntry) error
 	Query(time.Time, time.Time) ([]LogEntry, error)
 	Close()
 }
@@ -74,6 +111,49 @@ func (f *Firestore) Save(logEntry *LogEntry) error {
 	return nil
 }
 
+func (f *Firestore) Update(logEntry *LogEntry) error {
+	ctx := context.Background()
+	_, err := f.client.Collection("txs").Doc(logEntry.Hash).Set(ctx, logEntry)
+	if err != nil {
+		return fmt.Errorf("Failed to update transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (f *Firestore) Get(txHash string) (*LogEntry, error) {
+	id, err := storeID(txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	snapshot, err := f.client.Collection("txs").Doc(id).Get(ctx)
+	if snapshot != nil && !snapshot.Exists() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transaction: %v", err)
+	}
+
+	var logEntry LogEntry
+	err = snapshot.DataTo(&logEntry)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode transaction: %v", err)
+	}
+	return &logEntry, nil
+}
+
+func (f *Firestore) SaveGas(gasEntry *GasEntry) error {
+	ctx := context.Background()
+	_, _, err := f.client.Collection("gas").Add(ctx, gasEntry)
+	if err != nil {
+		return fmt.Errorf("Failed to add gas reading: %v", err)
+	}
+
+	return nil
+}
+
 func (f *Firestore) Query(from time.Time, to time.Time) ([]LogEntry, error) {
 	// TODO
 	return []LogEntry{}, nil
@@ -83,18 +163,41 @@ func (f *Firestore) Close() {
 	f.client.Close()
 }
 
+// Local keeps entries in memory for development
 type Local struct {
-	items []LogEntry
+	mx    sync.Mutex
+	items map[string]LogEntry
 }
 
 func NewLocal() (*Local, error) {
 	return &Local{
-		items: []LogEntry{},
+		items: map[string]LogEntry{},
 	}, nil
 }
 
+// Unlike Firestore, saving a known transaction replaces it
 func (l *Local) Save(logEntry *LogEntry) error {
-	// TODO
+	return l.Update(logEntry)
+}
+
+func (l *Local) Update(logEntry *LogEntry) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.items[logEntry.Transaction] = *logEntry
+	return nil
+}
+
+func (l *Local) Get(txHash string) (*LogEntry, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	logEntry, ok := l.items[txHash]
+	if !ok {
+		return nil, nil
+	}
+	return &logEntry, nil
+}
+
+func (l *Local) SaveGas(gasEntry *GasEntry) error {
 	return nil
 }
 
@@ -3,11 +3,13 @@ package types
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -118,3 +120,103 @@ func NewBundle(signer string, tx *types.Transaction, blockNumber uint64) (*Bundl
 		Header: header,
 	}, nil
 }
+
+// SendBundleArgs are the parameters of a Flashbots compatible
+// eth_sendBundle request
+// See: https://docs.flashbots.net/flashbots-auction/searchers/advanced/rpc-endpoint#eth_sendbundle
+type SendBundleArgs struct {
+	Txs               []string       `json:"txs"`
+	BlockNumber       hexutil.Uint64 `json:"blockNumber"`
+	MinTimestamp      *uint64        `json:"minTimestamp,omitempty"`
+	MaxTimestamp      *uint64        `json:"maxTimestamp,omitempty"`
+	RevertingTxHashes []common.Hash  `json:"revertingTxHashes,omitempty"`
+}
+
+// Decode and validate every transaction in the bundle
+func (b SendBundleArgs) Transactions() ([]*types.Transaction, error) {
+	if len(b.Txs) == 0 {
+		return nil, fmt.Errorf("Bundle has no transactions")
+	}
+
+	txs := make([]*types.Transaction, len(b.Txs))
+	for i, raw := range b.Txs {
+		tx, err := ParseTransaction(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid transaction %d: %s", i, err)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+// The bundle hash is the hash of the concatenated transaction hashes
+func (b SendBundleArgs) Hash() (common.Hash, error) {
+	txs, err := b.Transactions()
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	hashes := make([]byte, 0, len(txs)*common.HashLength)
+	for _, tx := range txs {
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(hashes), nil
+}
+
+func NewSendBundleRequest(bundle SendBundleArgs) JsRequest {
+	return JsRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  []interface{}{bundle},
+		ID:      json.RawMessage("1"),
+	}
+}
+
+// Each transaction in the bundle is encrypted to the bidder's public key,
+// the rest of the bundle is left readable
+func EncryptBundle(bundle SendBundleArgs, pub *ecdsa.PublicKey) (SendBundleArgs, error) {
+	txs, err := bundle.Transactions()
+	if err != nil {
+		return SendBundleArgs{}, err
+	}
+
+	encrypted := bundle
+	encrypted.Txs = make([]string, len(txs))
+	for i, tx := range txs {
+		encrypted.Txs[i], err = EncryptTransaction(tx, pub)
+		if err != nil {
+			return SendBundleArgs{}, err
+		}
+	}
+	return encrypted, nil
+}
+
+func NewSendEncryptedBundleRequest(bundle SendBundleArgs, pub *ecdsa.PublicKey) (JsRequest, error) {
+	encrypted, err := EncryptBundle(bundle, pub)
+	if err != nil {
+		return JsRequest{}, err
+	}
+	return JsRequest{
+		JSONRPC: "2.0",
+		Method:  "bukowskis_sendEncryptedBundle",
+		Params:  []interface{}{encrypted},
+		ID:      json.RawMessage("1"),
+	}, nil
+}
+
+// Decrypt the transactions of a bundle encrypted with EncryptBundle
+func DecryptBundle(bundle SendBundleArgs, key *ecdsa.PrivateKey) (SendBundleArgs, error) {
+	decrypted := bundle
+	decrypted.Txs = make([]string, len(bundle.Txs))
+	for i, ciphertext := range bundle.Txs {
+		tx, err := DecryptTransaction(ciphertext, key)
+		if err != nil {
+			return SendBundleArgs{}, err
+		}
+		decrypted.Txs[i], err = HexEncodeTransaction(tx)
+		if err != nil {
+			return SendBundleArgs{}, err
+		}
+	}
+	return decrypted, nil
+}
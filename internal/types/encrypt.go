@@ -0,0 +1,51 @@
+package types
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// Accepts both compressed and uncompressed hex encoded secp256k1 keys
+func ParsePublicKey(hexStr string) (*ecdsa.PublicKey, error) {
+	data := common.FromHex(hexStr)
+	if len(data) == 33 {
+		return crypto.DecompressPubkey(data)
+	}
+	return crypto.UnmarshalPubkey(data)
+}
+
+// Encrypt the binary encoding of the transaction to the public key with
+// ECIES and return it hex encoded
+func EncryptTransaction(tx *types.Transaction, pub *ecdsa.PublicKey) (string, error) {
+	data, err := tx.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), data, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encrypt transaction: %s", err)
+	}
+	return hexutil.Encode(ciphertext), nil
+}
+
+func DecryptTransaction(hexStr string, key *ecdsa.PrivateKey) (*types.Transaction, error) {
+	data, err := ecies.ImportECDSA(key).Decrypt(common.FromHex(hexStr), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt transaction: %s", err)
+	}
+
+	var tx types.Transaction
+	err = tx.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
@@ -0,0 +1,81 @@
+package types
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// How much of a transaction is released to bidders
+const (
+	HintModeFull         = "full"           // only the signed transaction
+	HintModeHint         = "hint"           // only the redacted hint
+	HintModeHintThenFull = "hint_then_full" // the hint followed by the transaction
+)
+
+// HintPolicy selects which fields of a transaction are revealed in a hint
+type HintPolicy struct {
+	Mode             string `json:"mode"`
+	To               bool   `json:"to"`
+	FunctionSelector bool   `json:"functionSelector"`
+	Value            bool   `json:"value"`
+	Gas              bool   `json:"gas"`
+	CallData         bool   `json:"callData"`
+}
+
+func (p HintPolicy) SendsHint() bool {
+	return p.Mode == HintModeHint || p.Mode == HintModeHintThenFull
+}
+
+func (p HintPolicy) SendsTransaction() bool {
+	return p.Mode == "" || p.Mode == HintModeFull || p.Mode == HintModeHintThenFull
+}
+
+// Hint is a redacted view of a transaction. Only the hash is always set.
+type Hint struct {
+	Hash             string          `json:"hash"`
+	To               string          `json:"to,omitempty"`
+	FunctionSelector string          `json:"functionSelector,omitempty"`
+	Value            *hexutil.Big    `json:"value,omitempty"`
+	Gas              *hexutil.Uint64 `json:"gas,omitempty"`
+	CallData         string          `json:"callData,omitempty"`
+}
+
+func NewHint(tx *types.Transaction, policy HintPolicy) Hint {
+	hint := Hint{
+		Hash: tx.Hash().Hex(),
+	}
+
+	if policy.To && tx.To() != nil {
+		hint.To = tx.To().Hex()
+	}
+
+	if policy.FunctionSelector && len(tx.Data()) >= 4 {
+		hint.FunctionSelector = hexutil.Encode(tx.Data()[:4])
+	}
+
+	if policy.Value {
+		hint.Value = (*hexutil.Big)(tx.Value())
+	}
+
+	if policy.Gas {
+		gas := hexutil.Uint64(tx.Gas())
+		hint.Gas = &gas
+	}
+
+	if policy.CallData && len(tx.Data()) > 0 {
+		hint.CallData = hexutil.Encode(tx.Data())
+	}
+
+	return hint
+}
+
+func NewSendHintRequest(hint Hint) JsRequest {
+	return JsRequest{
+		JSONRPC: "2.0",
+		Method:  "bukowskis_sendHint",
+		Params:  []interface{}{hint},
+		ID:      json.RawMessage("1"),
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,12 +12,102 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// See: http://www.jsonrpc.org/specification#request_object
 type JsRequest struct {
-	JSONRPC string        `json:"jsonrpc"`
-	Method  string        `json:"method"`
-	Params  []interface{} `json:"params,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  []interface{}   `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	// Params given by name instead of by position, in which case Params
+	// is empty
+	NamedParams json.RawMessage `json:"-"`
+	// Params which were neither, the request fails validation
+	InvalidParams json.RawMessage `json:"-"`
 }
 
+type jsRequestJSON struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Params may be an array or an object. Anything else fails validation
+// rather than decoding so the request's id is kept.
+func (r *JsRequest) UnmarshalJSON(data []byte) error {
+	var raw jsRequestJSON
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	*r = JsRequest{JSONRPC: raw.JSONRPC, Method: raw.Method, ID: raw.ID}
+	params := bytes.TrimSpace(raw.Params)
+	switch {
+	case len(params) == 0 || bytes.Equal(params, []byte("null")):
+	case params[0] == '[':
+		err = json.Unmarshal(params, &r.Params)
+		if err != nil {
+			return err
+		}
+	case params[0] == '{':
+		r.NamedParams = params
+	default:
+		r.InvalidParams = params
+	}
+	return nil
+}
+
+func (r JsRequest) MarshalJSON() ([]byte, error) {
+	raw := jsRequestJSON{JSONRPC: r.JSONRPC, Method: r.Method, ID: r.ID, Params: r.NamedParams}
+	if raw.Params == nil && len(r.Params) > 0 {
+		params, err := json.Marshal(r.Params)
+		if err != nil {
+			return nil, err
+		}
+		raw.Params = params
+	}
+	return json.Marshal(raw)
+}
+
+// Notifications have no id and receive no response
+func (r JsRequest) IsNotification() bool {
+	return r.ID == nil
+}
+
+func (r JsRequest) Validate() *JsError {
+	if r.JSONRPC != "2.0" || r.Method == "" {
+		return &JsError{Code: CodeInvalidRequest, Message: "Invalid Request"}
+	}
+	if r.InvalidParams != nil {
+		return &JsError{Code: CodeInvalidRequest, Message: "Invalid Request, params must be an array or object"}
+	}
+	if r.ID != nil {
+		switch r.ID[0] {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		default:
+			return &JsError{Code: CodeInvalidRequest, Message: "Invalid Request, id must be a string, number or null"}
+		}
+	}
+	return nil
+}
+
+// See: http://www.jsonrpc.org/specification#error_object
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Implementation defined server errors
+// See: https://eips.ethereum.org/EIPS/eip-1474#error-codes
+const (
+	CodeServerError         = -32000
+	CodeTransactionRejected = -32003
+	CodeServerBusy          = -32005
+)
+
 // See: http://www.jsonrpc.org/specification#error_object
 type JsError struct {
 	Code    int         `json:"code"`
@@ -30,16 +121,39 @@ func (e *JsError) Error() string {
 
 // See: http://www.jsonrpc.org/specification#response_object
 type JsResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *JsError    `json:"error,omitempty"`
-	ID      int         `json:"id"`
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JsError        `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Responses contain exactly one of result, which may be null, or error
+func (r JsResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *JsError        `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{"2.0", r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{"2.0", r.Result, r.ID})
+}
+
+func NewJsResult(id json.RawMessage, result interface{}) JsResponse {
+	return JsResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      id,
+	}
 }
 
 func ExecutionError() JsResponse {
 	return JsResponse{
-		JSONRPC: "2",
-		ID:      1,
+		JSONRPC: "2.0",
 		Error: &JsError{
 			Code:    3,
 			Message: "Execution error",
@@ -56,6 +170,20 @@ func NewSendRawRequest(tx *types.Transaction) (JsRequest, error) {
 		JSONRPC: "2.0",
 		Method:  "eth_sendRawTransaction",
 		Params:  []interface{}{hash},
+		ID:      json.RawMessage("1"),
+	}, nil
+}
+
+func NewSendEncryptedRequest(tx *types.Transaction, pub *ecdsa.PublicKey) (JsRequest, error) {
+	ciphertext, err := EncryptTransaction(tx, pub)
+	if err != nil {
+		return JsRequest{}, err
+	}
+	return JsRequest{
+		JSONRPC: "2.0",
+		Method:  "bukowskis_sendEncryptedTransaction",
+		Params:  []interface{}{ciphertext},
+		ID:      json.RawMessage("1"),
 	}, nil
 }
 
@@ -77,6 +205,37 @@ func ParseRequest(request *http.Request) (JsRequest, error) {
 	return jsr, nil
 }
 
+// ParseBatch decodes either a single request or a batch of requests.
+// Batch members which aren't request objects are returned with a null id
+// and no method so they fail validation.
+func ParseBatch(body []byte) ([]JsRequest, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		var jsr JsRequest
+		err := json.Unmarshal(body, &jsr)
+		if err != nil {
+			return nil, false, err
+		}
+		return []JsRequest{jsr}, false, nil
+	}
+
+	var raw []json.RawMessage
+	err := json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, true, err
+	}
+
+	batch := make([]JsRequest, len(raw))
+	for i, msg := range raw {
+		err := json.Unmarshal(msg, &batch[i])
+		if err != nil {
+			batch[i] = JsRequest{ID: json.RawMessage("null")}
+		}
+	}
+	return batch, true, nil
+}
+
+// The id is null, set it to the request's id when known
 func NewJsError(code int, message string) JsResponse {
 	return JsResponse{
 		JSONRPC: "2.0",
@@ -84,7 +243,6 @@ func NewJsError(code int, message string) JsResponse {
 			Code:    code,
 			Message: message,
 		},
-		ID: 1,
 	}
 }
 
@@ -105,3 +263,16 @@ func ExtractTransaction(req JsRequest) (*types.Transaction, error) {
 
 	return tx, nil
 }
+
+// pre-condition; this is a bukowskis_sendEncryptedTransaction
+func ExtractEncryptedTransaction(req JsRequest, key *ecdsa.PrivateKey) (*types.Transaction, error) {
+	if len(req.Params) != 1 {
+		return nil, fmt.Errorf("Invalid Request, too many params")
+	}
+	str, ok := req.Params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("Invalid Request, should be a string")
+	}
+
+	return DecryptTransaction(str, key)
+}
@@ -19,10 +19,43 @@ func ParseTransaction(hexStr string) (*types.Transaction, error) {
 	return &tx, nil
 }
 
+// UnsignTransaction rebuilds the transaction without its signature,
+// keeping its type
 func UnsignTransaction(tx *types.Transaction) *types.Transaction {
-	newTx := types.NewTransaction(tx.Nonce(), *(tx.To()), tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data())
-
-	return newTx
+	switch tx.Type() {
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      tx.Nonce(),
+			GasPrice:   tx.GasPrice(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      tx.Nonce(),
+			GasTipCap:  tx.GasTipCap(),
+			GasFeeCap:  tx.GasFeeCap(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	default:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: tx.GasPrice(),
+			Gas:      tx.Gas(),
+			To:       tx.To(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		})
+	}
 }
 
 func HexEncodeTransaction(tx *types.Transaction) (string, error) {
@@ -36,15 +69,29 @@ func HexEncodeTransaction(tx *types.Transaction) (string, error) {
 func PPTransaction(tx *types.Transaction) string {
 	fmtStr := `
 id: %s
+type: %d
 to: %s
 amount: %d
 gas: %d
+%s
 	`
+	to := "contract creation"
+	if tx.To() != nil {
+		to = hexutil.Encode(tx.To().Bytes())
+	}
+
+	fees := fmt.Sprintf("gasPrice: %d", tx.GasPrice())
+	if tx.Type() == types.DynamicFeeTxType {
+		fees = fmt.Sprintf("maxFeePerGas: %d\nmaxPriorityFeePerGas: %d", tx.GasFeeCap(), tx.GasTipCap())
+	}
+
 	return fmt.Sprintf(
 		fmtStr,
 		tx.Hash().Hex(),
-		hexutil.Encode(tx.To().Bytes()),
+		tx.Type(),
+		to,
 		tx.Value(),
 		tx.Gas(),
+		fees,
 	)
 }
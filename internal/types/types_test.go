@@ -0,0 +1,165 @@
+package types
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestTransactionHelpers(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(999)
+	signer := types.LatestSignerForChainID(chainID)
+	to := common.HexToAddress("0x000000000000000000000000000000000000dead")
+	accessList := types.AccessList{{Address: to, StorageKeys: []common.Hash{{1}}}}
+
+	cases := []struct {
+		name string
+		tx   types.TxData
+	}{
+		{"legacy", &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(2), Gas: 21000, To: &to, Value: big.NewInt(3)}},
+		{"legacy creation", &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(2), Gas: 60000, Data: []byte{0x60}}},
+		{"access list", &types.AccessListTx{ChainID: chainID, Nonce: 1, GasPrice: big.NewInt(2), Gas: 30000, To: &to, AccessList: accessList}},
+		{"access list creation", &types.AccessListTx{ChainID: chainID, Nonce: 1, GasPrice: big.NewInt(2), Gas: 60000, Data: []byte{0x60}}},
+		{"dynamic fee", &types.DynamicFeeTx{ChainID: chainID, Nonce: 1, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(5), Gas: 30000, To: &to, AccessList: accessList}},
+		{"dynamic fee creation", &types.DynamicFeeTx{ChainID: chainID, Nonce: 1, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(5), Gas: 60000, Data: []byte{0x60}}},
+	}
+
+	for _, c := range cases {
+		tx, err := types.SignNewTx(key, signer, c.tx)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		encoded, err := HexEncodeTransaction(tx)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		parsed, err := ParseTransaction(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if parsed.Hash() != tx.Hash() || parsed.Type() != tx.Type() {
+			t.Errorf("%s: re-encoding changed the transaction", c.name)
+		}
+
+		unsigned := UnsignTransaction(tx)
+		if unsigned.Type() != tx.Type() {
+			t.Errorf("%s: expected type %d, got %d", c.name, tx.Type(), unsigned.Type())
+		}
+		if (unsigned.To() == nil) != (tx.To() == nil) {
+			t.Errorf("%s: recipient changed", c.name)
+		}
+		if signer.Hash(unsigned) != signer.Hash(tx) {
+			t.Errorf("%s: unsigned transaction has a different signing hash", c.name)
+		}
+		if v, r, s := unsigned.RawSignatureValues(); v.Sign() != 0 || r.Sign() != 0 || s.Sign() != 0 {
+			t.Errorf("%s: signature was kept", c.name)
+		}
+
+		pretty := PPTransaction(tx)
+		if tx.To() == nil && !strings.Contains(pretty, "contract creation") {
+			t.Errorf("%s: expected a contract creation, got %s", c.name, pretty)
+		}
+		if tx.Type() == types.DynamicFeeTxType && !strings.Contains(pretty, "maxFeePerGas: 5") {
+			t.Errorf("%s: expected the fee cap, got %s", c.name, pretty)
+		}
+	}
+}
+
+func TestEncryptTransaction(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+	pub, err := ParsePublicKey(common.Bytes2Hex(crypto.CompressPubkey(&key.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := EncryptTransaction(tx, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptTransaction(ciphertext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Hash() != tx.Hash() {
+		t.Errorf("expected %s, got %s", tx.Hash().Hex(), decrypted.Hash().Hex())
+	}
+
+	if _, err := DecryptTransaction(ciphertext, other); err == nil {
+		t.Error("expected decryption with the wrong key to fail")
+	}
+}
+
+func TestNewHint(t *testing.T) {
+	to := common.HexToAddress("0x000000000000000000000000000000000000dead")
+	data := []byte{0xa9, 0x05, 0x9c, 0xbb, 0x01}
+	tx := types.NewTransaction(1, to, big.NewInt(3), 50000, big.NewInt(1), data)
+	creation := types.NewContractCreation(1, big.NewInt(0), 60000, big.NewInt(1), []byte{0x60})
+
+	cases := []struct {
+		name     string
+		tx       *types.Transaction
+		policy   HintPolicy
+		expected string
+	}{
+		{"hash only", tx, HintPolicy{}, `{"hash":"` + tx.Hash().Hex() + `"}`},
+		{"to and selector", tx, HintPolicy{To: true, FunctionSelector: true},
+			`{"hash":"` + tx.Hash().Hex() + `","to":"` + to.Hex() + `","functionSelector":"0xa9059cbb"}`},
+		{"value and gas", tx, HintPolicy{Value: true, Gas: true},
+			`{"hash":"` + tx.Hash().Hex() + `","value":"0x3","gas":"0xc350"}`},
+		{"call data", tx, HintPolicy{CallData: true},
+			`{"hash":"` + tx.Hash().Hex() + `","callData":"0xa9059cbb01"}`},
+		{"creation", creation, HintPolicy{To: true, FunctionSelector: true},
+			`{"hash":"` + creation.Hash().Hex() + `"}`},
+	}
+
+	for _, c := range cases {
+		encoded, err := json.Marshal(NewHint(c.tx, c.policy))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if string(encoded) != c.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", c.name, c.expected, encoded)
+		}
+	}
+}
+
+// Params by name are kept as given when the request is encoded again
+func TestJsRequestNamedParams(t *testing.T) {
+	body := `{"jsonrpc":"2.0","method":"m","params":{"a":1},"id":1}`
+	var jsr JsRequest
+	if err := json.Unmarshal([]byte(body), &jsr); err != nil {
+		t.Fatal(err)
+	}
+	if len(jsr.Params) != 0 || string(jsr.NamedParams) != `{"a":1}` || jsr.Validate() != nil {
+		t.Fatalf("expected valid named params, got %+v", jsr)
+	}
+	encoded, err := json.Marshal(jsr)
+	if err != nil || string(encoded) != body {
+		t.Errorf("expected %s, got %s %v", body, encoded, err)
+	}
+
+	positional := JsRequest{JSONRPC: "2.0", Method: "m", Params: []interface{}{"a"}, ID: json.RawMessage("1")}
+	encoded, err = json.Marshal(positional)
+	if err != nil || string(encoded) != `{"jsonrpc":"2.0","method":"m","params":["a"],"id":1}` {
+		t.Errorf("unexpected positional encoding %s %v", encoded, err)
+	}
+}